@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/lonegunmanb/yorbox/pkg"
 )
@@ -28,15 +35,103 @@ func main() {
 	flag.StringVar(&toggleName, "toggleName", "tracing_tags_enabled", "Name of the toggle to add")
 
 	var boxTemplate string
-	flag.StringVar(&boxTemplate, "boxTemplate", "/*<box>*/ (var.{{ .toggleName }} ? /*</box>*/ { yor_trace = 123 } /*<box>*/ : {} ) /*</box>*/",
+	flag.StringVar(&boxTemplate, "boxTemplate", pkg.DefaultBoxTemplate,
 		"Box template to use when adding boxes")
 
 	var tagsPrefix string
 	flag.StringVar(&tagsPrefix, "tagsPrefix", "", "Prefix for tags applied to resources")
 
+	var boxOpenMarker string
+	flag.StringVar(&boxOpenMarker, "boxOpenMarker", pkg.DefaultBoxMarkers.Open, "Comment that marks the beginning of a box")
+
+	var boxCloseMarker string
+	flag.StringVar(&boxCloseMarker, "boxCloseMarker", pkg.DefaultBoxMarkers.Close, "Comment that marks the end of a box")
+
+	var layersFile string
+	flag.StringVar(&layersFile, "layersFile", "", "Path to a JSON file that defines named box layers, the first layer is the innermost")
+
+	var omitParens bool
+	flag.BoolVar(&omitParens, "omitParens", false, "Don't wrap boxes with an extra pair of parens, the box template decides the parens")
+
+	var structural bool
+	flag.BoolVar(&structural, "structural", false, "Recognise existing boxes by the structure of the box template, even if their comment markers have been stripped")
+
+	var repair bool
+	flag.BoolVar(&repair, "repair", false, "Reconstruct boxes with unbalanced box markers instead of reporting them as errors")
+
+	var terratag bool
+	flag.BoolVar(&terratag, "terratag", false, "Also box locals generated by terratag and their references in tags, e.g. local.terratag_added_main")
+
 	var ignoreResourceTypes arrayFlags
 	flag.Var(&ignoreResourceTypes, "ignoreResourceType", "Resource types to ignore")
 
+	var splitGitTags bool
+	flag.BoolVar(&splitGitTags, "splitGitTags", false, "Box git metadata tags separately from yor_trace and other tags")
+
+	var gitToggleName string
+	flag.StringVar(&gitToggleName, "gitToggleName", "yor_git_toggle", "Name of the toggle for git metadata tags")
+
+	var gitBoxTemplate string
+	flag.StringVar(&gitBoxTemplate, "gitBoxTemplate", pkg.DefaultGitBoxTemplate,
+		"Box template to use when adding boxes to git metadata tags")
+
+	var stripTags arrayFlags
+	flag.Var(&stripTags, "stripTag", "Key of the yor tag to remove, e.g. git_last_modified_by")
+
+	var redactTags arrayFlags
+	flag.Var(&redactTags, "redactTag", "Key of the yor tag whose value should be redacted, e.g. git_modifiers")
+
+	var redactTemplate string
+	flag.StringVar(&redactTemplate, "redactTemplate", `"redacted"`, "Template of the expression that replaces redacted values, {{ .key }} is available")
+
+	var migrate bool
+	flag.BoolVar(&migrate, "migrate", false, "Report existing boxes and migrate them to the current box template")
+
+	var knownTemplates arrayFlags
+	flag.Var(&knownTemplates, "knownTemplate", "Previously used box template that existing boxes could be migrated from")
+
+	var knownToggleNames arrayFlags
+	flag.Var(&knownToggleNames, "knownToggleName", "Previously used toggle name that existing boxes could be migrated from, the current and known templates are rendered with it")
+
+	var force bool
+	flag.BoolVar(&force, "force", false, "Migrate boxes even if they don't match any known template")
+
+	var stdin bool
+	flag.BoolVar(&stdin, "stdin", false, "Read HCL from stdin and write the boxed result to stdout, same as passing - as the only argument")
+
+	var filename string
+	flag.StringVar(&filename, "filename", "stdin.tf", "File name used in diagnostics when reading from stdin")
+
+	var archive string
+	flag.StringVar(&archive, "archive", "", "Path to a .zip, .tar.gz or .tgz module archive whose .tf files should be boxed")
+
+	var archiveOutput string
+	flag.StringVar(&archiveOutput, "archiveOutput", "", "Path to write the boxed archive to, defaults to the archive's path with .boxed before the extension, e.g. network.boxed.zip")
+
+	var changedSince string
+	flag.StringVar(&changedSince, "changed-since", "", "Only process .tf files added or modified relative to the git ref, an empty ref compares with the index")
+
+	var report string
+	flag.StringVar(&report, "report", "", "Print a machine-readable report of boxing results to stdout, supported formats: json, sarif")
+
+	var check bool
+	flag.BoolVar(&check, "check", false, "Don't write files, exit with 1 if any file needs boxing")
+
+	var verbose bool
+	flag.BoolVar(&verbose, "v", false, "Log decisions made while boxing to stderr, same as -log-level debug")
+
+	var logLevel string
+	flag.StringVar(&logLevel, "log-level", "", "Log level of structured logs printed to stderr: debug, info, warn or error")
+
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", "text", "Format of structured logs: text or json")
+
+	var parallelism int
+	flag.IntVar(&parallelism, "parallelism", 0, "Number of files processed concurrently, defaults to GOMAXPROCS")
+
+	var timeout time.Duration
+	flag.DurationVar(&timeout, "timeout", 0, "Stop processing files after the duration, e.g. 5m, files that have not been processed are listed")
+
 	var help bool
 	flag.BoolVar(&help, "help", false, "Print help information")
 
@@ -44,43 +139,272 @@ func main() {
 
 	if help {
 		// Print help information
-		fmt.Println("Usage: yorbox -dir <directory path> [-toggleName <toggle name>] [-boxTemplate <box template>] [-tagsPrefix <tags prefix>] [-ignoreResourceType <ignore resource type> ...]")
+		fmt.Println("Usage: yorbox {-dir <directory path> | <file or directory path> ... | -stdin [-filename <file name>] | - | -archive <archive path> [-archiveOutput <archive path>]} [-changed-since <git ref>] [-v] [-log-level <level>] [-log-format {text|json}] [-parallelism <n>] [-timeout <duration>] [-check] [-report {json|sarif}] [-toggleName <toggle name>] [-boxTemplate <box template>] [-tagsPrefix <tags prefix>] [-boxOpenMarker <open marker>] [-boxCloseMarker <close marker>] [-layersFile <layers file>] [-omitParens] [-structural] [-repair] [-splitGitTags [-gitToggleName <toggle name>] [-gitBoxTemplate <box template>]] [-ignoreResourceType <ignore resource type> ...] [-stripTag <tag key> ...] [-redactTag <tag key> ...] [-redactTemplate <redact template>] [-migrate [-knownTemplate <box template> ...] [-knownToggleName <toggle name> ...] [-force]]")
 		flag.PrintDefaults()
 		return
 	}
 
-	if dirPath == "" {
-		fmt.Println("Directory path is required. Use -help for more information.")
+	stdin = stdin || (flag.NArg() == 1 && flag.Arg(0) == "-")
+
+	paths := flag.Args()
+	if stdin {
+		paths = nil
+	}
+	if flagSet("changed-since") {
+		dir := dirPath
+		if dir == "" {
+			dir = "."
+		}
+		changed, err := pkg.ChangedFiles(dir, changedSince)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error listing changed files:", err)
+			os.Exit(1)
+		}
+		if len(changed) == 0 {
+			return
+		}
+		paths = changed
+	}
+
+	if dirPath == "" && len(paths) == 0 && !stdin && archive == "" {
+		fmt.Println("Directory path or file paths are required. Use -help for more information.")
 		return
 	}
 
 	options := pkg.NewOptions(dirPath, toggleName, boxTemplate, tagsPrefix, ignoreResourceTypes)
+	options.BoxMarkers = pkg.BoxMarkers{
+		Open:  boxOpenMarker,
+		Close: boxCloseMarker,
+	}
+	if layersFile != "" {
+		layers, err := readLayers(layersFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading box layers:", err)
+			os.Exit(1)
+		}
+		options.Layers = layers
+	}
+	options.OmitParens = omitParens
+	options.Structural = structural
+	options.Repair = repair
+	options.SplitGitTags = splitGitTags
+	options.GitToggleName = gitToggleName
+	options.GitBoxTemplate = gitBoxTemplate
+	options.StripTags = stripTags
+	options.RedactTags = redactTags
+	options.RedactTemplate = redactTemplate
+	options.KnownTemplates = knownTemplates
+	options.KnownToggleNames = knownToggleNames
+	options.Force = force
+	options.Check = check
+	options.Parallelism = parallelism
+	if verbose && logLevel == "" {
+		logLevel = "debug"
+	}
+	if logLevel != "" {
+		logger, err := newLogger(logLevel, logFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating logger:", err)
+			os.Exit(1)
+		}
+		options.Logger = logger
+	}
+
+	if terratag {
+		options.TagDetector = pkg.NewTerratagDetector(options)
+	}
 
 	valid := optionValid(options)
 	if !valid {
 		os.Exit(1)
 	}
+	if report != "" && report != "json" && report != "sarif" {
+		fmt.Fprintln(os.Stderr, "Unsupported report format:", report)
+		os.Exit(1)
+	}
+	if report == "sarif" && !check {
+		fmt.Fprintln(os.Stderr, "-report sarif requires -check")
+		os.Exit(1)
+	}
 
-	err := pkg.ProcessDirectory(options)
+	if stdin {
+		if err := pkg.ProcessStream(os.Stdin, os.Stdout, filename, options); err != nil {
+			fmt.Fprintln(os.Stderr, "Error processing stdin:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if archive != "" {
+		if archiveOutput == "" {
+			archiveOutput = pkg.BoxedArchivePath(archive)
+		}
+		results, err := pkg.BoxArchive(archive, archiveOutput, options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error processing archive:", err)
+			os.Exit(1)
+		}
+		if !writeReport(report, results, pkg.WriteArchiveSARIFReport) || !printDiagnostics(results) {
+			os.Exit(1)
+		}
+		if check {
+			for _, r := range results {
+				if r.Modified {
+					os.Exit(1)
+				}
+			}
+		}
+		return
+	}
+
+	if migrate {
+		migrations, err := pkg.MigrateDirectory(options)
+		for _, m := range migrations {
+			fmt.Println(m)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error migrating directory:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Directory migrated successfully.")
+		return
+	}
+
+	// Stop between files on interrupt or timeout, files that have been written are always complete
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if len(paths) > 0 {
+		// Behave like a pre-commit hook: nothing is printed on success, exit with 1 if any file has been modified.
+		results, err := pkg.ProcessFilesContext(ctx, paths, options)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error processing files:", err)
+			os.Exit(1)
+		}
+		if !writeReport(report, results, pkg.WriteSARIFReport) || !printDiagnostics(results) {
+			os.Exit(1)
+		}
+		for _, r := range results {
+			if r.Modified {
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	results, err := pkg.ProcessFilesContext(ctx, []string{dirPath}, options)
 
 	if err != nil {
-		fmt.Println("Error processing directory:", err)
+		fmt.Fprintln(os.Stderr, "Error processing directory:", err)
+		os.Exit(1)
+	}
+
+	if !writeReport(report, results, pkg.WriteSARIFReport) || !printDiagnostics(results) {
+		os.Exit(1)
+	}
+	if check {
+		needBoxing := false
+		for _, r := range results {
+			if r.Modified {
+				needBoxing = true
+				if report == "" {
+					fmt.Println(r.Path)
+				}
+			}
+		}
+		if needBoxing {
+			os.Exit(1)
+		}
+		return
+	}
+	if report != "" {
 		return
 	}
 
 	fmt.Println("Directory processed successfully.")
 }
 
+// writeReport writes the report of results in format to stdout. SARIF logs are written by writeSARIF, as paths of
+// results are relative to the working directory, or to the root of an archive.
+func writeReport(format string, results []pkg.FileResult, writeSARIF func(io.Writer, []pkg.FileResult) error) bool {
+	if format == "" {
+		return true
+	}
+	write := pkg.WriteJSONReport
+	if format == "sarif" {
+		write = writeSARIF
+	}
+	if err := write(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing report:", err)
+		return false
+	}
+	return true
+}
+
+// printDiagnostics prints diagnostics of attributes that have been left as is, e.g. with unbalanced box markers, to
+// stderr. It returns false if there is any.
+func printDiagnostics(results []pkg.FileResult) bool {
+	ok := true
+	for _, r := range results {
+		for _, diag := range r.Diagnostics() {
+			fmt.Fprintln(os.Stderr, diag.Error())
+			ok = false
+		}
+	}
+	return ok
+}
+
+func newLogger(level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
+	}
+	handlerOptions := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func optionValid(options pkg.Options) bool {
-	tplt, err := options.RenderBoxTemplate()
-	if err != nil {
-		fmt.Println("Error rendering box template:", err)
+	if err := options.ValidateLayers(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error building box from template:", err)
 		return false
 	}
-	_, diag := pkg.BuildBoxFromTemplate(tplt)
-	if diag.HasErrors() {
-		fmt.Println("Error building box from template:", diag.Error())
+	if err := options.ValidateRedactTemplate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error rendering redact template:", err)
 		return false
 	}
 	return true
 }
+
+func readLayers(path string) ([]pkg.BoxLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var layers []pkg.BoxLayer
+	if err = json.Unmarshal(data, &layers); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
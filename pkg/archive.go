@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BoxArchive boxes all .tf files in a zip or tar.gz archive, the format is decided by the extension of src: .zip,
+// .tar.gz or .tgz. A new archive of the same format is written to dst, which could be the same as src. Other entries,
+// file modes and the order of entries are preserved. Nothing is written if Options.Check is set.
+func BoxArchive(src, dst string, options Options) ([]FileResult, error) {
+	if !isZip(src) && !isTarGz(src) {
+		return nil, fmt.Errorf("%s is not a supported archive, only .zip, .tar.gz and .tgz files could be boxed", src)
+	}
+	boxer, err := NewBoxer(options)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	var results []FileResult
+	if isZip(src) {
+		results, err = boxer.BoxZip(in, info.Size(), out)
+	} else {
+		results, err = boxer.BoxTarGz(in, out)
+	}
+	if err != nil || options.Check {
+		return results, err
+	}
+	// Write to a temporary file first, so src is intact if anything goes wrong even if it's the same as dst
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return results, err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(out.Bytes()); err != nil {
+		_ = tmp.Close()
+		return results, err
+	}
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return results, err
+	}
+	if err = tmp.Close(); err != nil {
+		return results, err
+	}
+	return results, os.Rename(tmp.Name(), dst)
+}
+
+// BoxedArchivePath returns the default path of the boxed archive of src, the archive's extension is kept, e.g.
+// network.boxed.zip for network.zip.
+func BoxedArchivePath(src string) string {
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(src, ext) {
+			return strings.TrimSuffix(src, ext) + ".boxed" + ext
+		}
+	}
+	return src + ".boxed"
+}
+
+func isZip(name string) bool {
+	return strings.HasSuffix(name, ".zip")
+}
+
+func isTarGz(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// BoxZip reads a zip archive and writes it to out with all .tf files boxed. Entries that are not changed are copied
+// without being recompressed.
+func (b *Boxer) BoxZip(in io.ReaderAt, size int64, out io.Writer) ([]FileResult, error) {
+	r, err := zip.NewReader(in, size)
+	if err != nil {
+		return nil, err
+	}
+	w := zip.NewWriter(out)
+	var results []FileResult
+	for _, f := range r.File {
+		if !isArchivedTerraformFile(f.Name, f.FileInfo().Mode().IsRegular()) {
+			if err = w.Copy(f); err != nil {
+				return results, err
+			}
+			continue
+		}
+		data, err := readZipFile(f)
+		if err != nil {
+			return results, err
+		}
+		boxed, result, err := b.boxArchivedFile(f.Name, data)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		if !result.Modified {
+			if err = w.Copy(f); err != nil {
+				return results, err
+			}
+			continue
+		}
+		header := f.FileHeader
+		fw, err := w.CreateHeader(&header)
+		if err != nil {
+			return results, err
+		}
+		if _, err = fw.Write(boxed); err != nil {
+			return results, err
+		}
+	}
+	if err = w.SetComment(r.Comment); err != nil {
+		return results, err
+	}
+	return results, w.Close()
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	return io.ReadAll(rc)
+}
+
+// BoxTarGz reads a gzipped tar archive and writes it to out with all .tf files boxed.
+func (b *Boxer) BoxTarGz(in io.Reader, out io.Writer) ([]FileResult, error) {
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Header = zr.Header
+	tr := tar.NewReader(zr)
+	tw := tar.NewWriter(zw)
+	var results []FileResult
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, err
+		}
+		if !isArchivedTerraformFile(header.Name, header.Typeflag == tar.TypeReg) {
+			if err = tw.WriteHeader(header); err != nil {
+				return results, err
+			}
+			if _, err = io.Copy(tw, tr); err != nil {
+				return results, err
+			}
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return results, err
+		}
+		boxed, result, err := b.boxArchivedFile(header.Name, data)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		header.Size = int64(len(boxed))
+		if err = tw.WriteHeader(header); err != nil {
+			return results, err
+		}
+		if _, err = tw.Write(boxed); err != nil {
+			return results, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return results, err
+	}
+	return results, zw.Close()
+}
+
+func isArchivedTerraformFile(name string, regular bool) bool {
+	return regular && path.Ext(name) == ".tf"
+}
+
+func (b *Boxer) boxArchivedFile(name string, data []byte) ([]byte, FileResult, error) {
+	result := FileResult{Path: name}
+	boxed, blocks, err := b.boxBytes(data, name)
+	if err != nil {
+		return nil, result, err
+	}
+	result.Blocks = blocks
+	result.Modified = !bytes.Equal(data, boxed)
+	return boxed, result, nil
+}
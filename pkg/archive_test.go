@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const boxedCode = `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`
+
+type archiveEntry struct {
+	Name string
+	Mode fs.FileMode
+	Data string
+}
+
+var archiveEntries = []archiveEntry{
+	{Name: "module/", Mode: fs.ModeDir | 0755},
+	{Name: "module/main.tf", Mode: 0640, Data: unboxedCode},
+	{Name: "module/scripts/run.sh", Mode: 0755, Data: "#!/bin/sh\necho hello\n"},
+	{Name: "module/boxed.tf", Mode: 0644, Data: boxedCode},
+	{Name: "module/README.md", Mode: 0644, Data: "# module\n"},
+}
+
+func writeZip(t *testing.T, path string, entries []archiveEntry) {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.Name, Method: zip.Deflate}
+		header.SetMode(e.Mode)
+		fw, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = fw.Write([]byte(e.Data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+}
+
+func readZip(t *testing.T, path string) []archiveEntry {
+	r, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = r.Close()
+	}()
+	var entries []archiveEntry
+	for _, f := range r.File {
+		data, err := readZipFile(f)
+		require.NoError(t, err)
+		entries = append(entries, archiveEntry{Name: f.Name, Mode: f.Mode(), Data: string(data)})
+	}
+	return entries
+}
+
+func writeTarGz(t *testing.T, path string, entries []archiveEntry) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.Name, Mode: int64(e.Mode.Perm()), Size: int64(len(e.Data)), Typeflag: tar.TypeReg}
+		if e.Mode.IsDir() {
+			header.Typeflag = tar.TypeDir
+		}
+		require.NoError(t, tw.WriteHeader(header))
+		_, err := tw.Write([]byte(e.Data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0600))
+}
+
+func readTarGz(t *testing.T, path string) []archiveEntry {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(zr)
+	var entries []archiveEntry
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries = append(entries, archiveEntry{Name: header.Name, Mode: header.FileInfo().Mode(), Data: string(data)})
+	}
+	return entries
+}
+
+func TestBoxArchive(t *testing.T) {
+	inputs := []struct {
+		name  string
+		file  string
+		write func(*testing.T, string, []archiveEntry)
+		read  func(*testing.T, string) []archiveEntry
+	}{
+		{name: "zip", file: "module.zip", write: writeZip, read: readZip},
+		{name: "tar.gz", file: "module.tar.gz", write: writeTarGz, read: readTarGz},
+		{name: "tgz", file: "module.tgz", write: writeTarGz, read: readTarGz},
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, input.file)
+			dst := filepath.Join(dir, "boxed-"+input.file)
+			input.write(t, src, archiveEntries)
+
+			results, err := BoxArchive(src, dst, NewOptions("", "yor_toggle", "", "", nil))
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+			assert.Equal(t, "module/main.tf", results[0].Path)
+			assert.True(t, results[0].Modified)
+			assert.Equal(t, "module/boxed.tf", results[1].Path)
+			assert.False(t, results[1].Modified)
+
+			entries := input.read(t, dst)
+			require.Len(t, entries, len(archiveEntries))
+			for i, entry := range entries {
+				assert.Equal(t, archiveEntries[i].Name, entry.Name)
+				assert.Equal(t, archiveEntries[i].Mode, entry.Mode)
+				if entry.Name == "module/main.tf" {
+					assert.Equal(t, formatHcl(t, boxedCode), formatHcl(t, entry.Data))
+					continue
+				}
+				assert.Equal(t, archiveEntries[i].Data, entry.Data)
+			}
+			assert.Equal(t, archiveEntries, input.read(t, src))
+		})
+	}
+}
+
+func TestBoxArchive_InPlace(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "module.zip")
+	writeZip(t, src, archiveEntries)
+
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Check = true
+	results, err := BoxArchive(src, src, options)
+	require.NoError(t, err)
+	assert.True(t, results[0].Modified)
+	assert.Equal(t, archiveEntries, readZip(t, src))
+
+	_, err = BoxArchive(src, src, NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	assert.Equal(t, formatHcl(t, boxedCode), formatHcl(t, readZip(t, src)[1].Data))
+	info, err := os.Stat(src)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), info.Mode().Perm())
+}
+
+func TestBoxArchive_Errors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := BoxArchive(filepath.Join(dir, "module.rar"), filepath.Join(dir, "out.rar"), NewOptions("", "yor_toggle", "", "", nil))
+	assert.ErrorContains(t, err, "not a supported archive")
+
+	src := filepath.Join(dir, "module.zip")
+	dst := filepath.Join(dir, "out.zip")
+	writeZip(t, src, []archiveEntry{{Name: "main.tf", Mode: 0644, Data: `resource "example_resource" {`}})
+	_, err = BoxArchive(src, dst, NewOptions("", "yor_toggle", "", "", nil))
+	assert.Error(t, err)
+	_, err = os.Stat(dst)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBoxedArchivePath(t *testing.T) {
+	assert.Equal(t, "network.boxed.zip", BoxedArchivePath("network.zip"))
+	assert.Equal(t, "dist/network.boxed.tar.gz", BoxedArchivePath("dist/network.tar.gz"))
+	assert.Equal(t, "network.boxed.tgz", BoxedArchivePath("network.tgz"))
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// BoxLayer is a named box with its own markers and template. Layers are applied in order, the first layer is
+// the innermost one, so every layer can be removed and re-added without touching the others.
+type BoxLayer struct {
+	Name        string `json:"name"`
+	BoxTemplate string `json:"boxTemplate"`
+	BoxMarkers
+}
+
+// BoxLayers returns the configured layers, or a single layer built from BoxTemplate and BoxMarkers when no
+// layer has been configured.
+func (o Options) BoxLayers() []BoxLayer {
+	if len(o.Layers) == 0 {
+		return []BoxLayer{
+			{
+				Name:        "default",
+				BoxTemplate: o.BoxTemplate,
+				BoxMarkers:  o.BoxMarkers,
+			},
+		}
+	}
+	layers := make([]BoxLayer, len(o.Layers))
+	for i, layer := range o.Layers {
+		if layer.BoxTemplate == "" {
+			layer.BoxTemplate = o.BoxTemplate
+		}
+		if layer.BoxMarkers == (BoxMarkers{}) {
+			layer.BoxMarkers = o.BoxMarkers
+		}
+		layers[i] = layer
+	}
+	return layers
+}
+
+// ValidateLayers ensures every layer could be rendered into a box and could be told apart from the others.
+func (o Options) ValidateLayers() error {
+	names := make(map[string]struct{})
+	markers := make(map[string]string)
+	for _, layer := range o.BoxLayers() {
+		if _, ok := names[layer.Name]; ok {
+			return fmt.Errorf("duplicate box layer name %q", layer.Name)
+		}
+		names[layer.Name] = struct{}{}
+		if err := layer.BoxMarkers.Validate(); err != nil {
+			return fmt.Errorf("box layer %q: %w", layer.Name, err)
+		}
+		for _, marker := range []string{layer.Open, layer.Close} {
+			if other, ok := markers[marker]; ok {
+				return fmt.Errorf("box layer %q: marker %s is already used by layer %q", layer.Name, marker, other)
+			}
+			markers[marker] = layer.Name
+		}
+		if _, diag := o.buildLayerBox(layer); diag.HasErrors() {
+			return fmt.Errorf("box layer %q: %s", layer.Name, diag.Error())
+		}
+	}
+	if o.SplitGitTags {
+		if _, diag := o.buildLayerBox(o.gitLayer()); diag.HasErrors() {
+			return fmt.Errorf("git box template: %s", diag.Error())
+		}
+	}
+	return nil
+}
+
+func (o Options) buildLayerBox(layer BoxLayer) (Box, hcl.Diagnostics) {
+	tplt, err := o.renderBoxTemplate(layer.BoxTemplate, layer.BoxMarkers)
+	if err != nil {
+		return Box{}, hcl.Diagnostics{&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Cannot render box template",
+			Detail:   err.Error(),
+		}}
+	}
+	box, diag := BuildBoxFromTemplateWithMarkers(tplt, layer.BoxMarkers)
+	if o.OmitParens && !diag.HasErrors() {
+		box = box.withoutParens()
+	}
+	return box, diag
+}
+
+// buildLayeredBox nests the boxes of all layers, the first layer is wrapped by the second one, and so on.
+func (o Options) buildLayeredBox() (Box, hcl.Diagnostics) {
+	result := Box{}
+	for _, layer := range o.BoxLayers() {
+		box, diag := o.buildLayerBox(layer)
+		if diag.HasErrors() {
+			return Box{}, diag
+		}
+		result.Left = append(box.Left, result.Left...)
+		result.Right = append(result.Right, box.Right...)
+	}
+	return result, nil
+}
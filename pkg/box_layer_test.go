@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var prefixLayer = BoxLayer{
+	Name:        "prefix",
+	BoxTemplate: `/*<prefix>*/ { for k, v in /*</prefix>*/ { yor_trace = 123 } /*<prefix>*/ : "my_prefix_${k}" => v } /*</prefix>*/`,
+	BoxMarkers:  BoxMarkers{Open: "/*<prefix>*/", Close: "/*</prefix>*/"},
+}
+
+var toggleLayer = BoxLayer{
+	Name:        "toggle",
+	BoxTemplate: `/*<toggle>*/ (var.{{ .toggleName }} ? /*</toggle>*/ { yor_trace = 123 } /*<toggle>*/ : {}) /*</toggle>*/`,
+	BoxMarkers:  BoxMarkers{Open: "/*<toggle>*/", Close: "/*</toggle>*/"},
+}
+
+func TestBoxLayers(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	expected := `resource "example_resource" "example_instance" {
+  tags = (/*<toggle>*/ (var.yor_toggle ? /*</toggle>*/(/*<prefix>*/ { for k, v in /*</prefix>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<prefix>*/ : "my_prefix_${k}" => v } /*</prefix>*/)/*<toggle>*/ : {}) /*</toggle>*/)
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Layers = []BoxLayer{prefixLayer, toggleLayer}
+	require.NoError(t, options.ValidateLayers())
+
+	file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	BoxFile(file, options)
+	assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+
+	file, diags = hclwrite.ParseConfig(file.Bytes(), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	BoxFile(file, options)
+	assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+}
+
+func TestBoxLayersUpdateIndependently(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = (/*<toggle>*/ (var.yor_toggle ? /*</toggle>*/(/*<prefix>*/ { for k, v in /*</prefix>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<prefix>*/ : "my_prefix_${k}" => v } /*</prefix>*/)/*<toggle>*/ : {}) /*</toggle>*/)
+}
+`
+	expected := `resource "example_resource" "example_instance" {
+  tags = (/*<toggle>*/ (var.yor_toggle ? /*</toggle>*/(/*<prefix>*/ { for k, v in /*</prefix>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<prefix>*/ : "another_prefix_${k}" => v } /*</prefix>*/)/*<toggle>*/ : {}) /*</toggle>*/)
+}
+`
+	newPrefixLayer := prefixLayer
+	newPrefixLayer.BoxTemplate = `/*<prefix>*/ { for k, v in /*</prefix>*/ { yor_trace = 123 } /*<prefix>*/ : "another_prefix_${k}" => v } /*</prefix>*/`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Layers = []BoxLayer{newPrefixLayer, toggleLayer}
+
+	file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	BoxFile(file, options)
+	assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+}
+
+func TestBoxLayersLeaveUnknownBoxesIntact(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/(var.yor_toggle ? /*</box>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<box>*/ : {})/*</box>*/)
+}
+`
+	expected := `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/(var.yor_toggle ? /*</box>*/(/*<prefix>*/ { for k, v in /*</prefix>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<prefix>*/ : "my_prefix_${k}" => v } /*</prefix>*/)/*<box>*/ : {})/*</box>*/)
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Layers = []BoxLayer{prefixLayer}
+
+	file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	BoxFile(file, options)
+	assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+}
+
+func TestValidateLayers(t *testing.T) {
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	require.NoError(t, options.ValidateLayers())
+
+	options.Layers = []BoxLayer{prefixLayer, prefixLayer}
+	assert.ErrorContains(t, options.ValidateLayers(), "duplicate box layer name")
+
+	sameMarkers := toggleLayer
+	sameMarkers.BoxMarkers = prefixLayer.BoxMarkers
+	options.Layers = []BoxLayer{prefixLayer, sameMarkers}
+	assert.ErrorContains(t, options.ValidateLayers(), "is already used by layer")
+
+	wrongMarkers := toggleLayer
+	wrongMarkers.BoxMarkers = DefaultBoxMarkers
+	options.Layers = []BoxLayer{wrongMarkers}
+	assert.ErrorContains(t, options.ValidateLayers(), "Box markers not found")
+}
+
+func TestBoxLayersFallBackToOptions(t *testing.T) {
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.BoxMarkers = BoxMarkers{Open: "/*<custom>*/", Close: "/*</custom>*/"}
+	options.BoxTemplate = `/*<custom>*/ (var.{{ .toggleName }} ? /*</custom>*/ { yor_trace = 123 } /*<custom>*/ : {}) /*</custom>*/`
+	options.Layers = []BoxLayer{prefixLayer, {Name: "toggle"}}
+	require.NoError(t, options.ValidateLayers())
+
+	layers := options.BoxLayers()
+	require.Len(t, layers, 2)
+	assert.Equal(t, prefixLayer, layers[0])
+	assert.Equal(t, options.BoxTemplate, layers[1].BoxTemplate)
+	assert.Equal(t, options.BoxMarkers, layers[1].BoxMarkers)
+}
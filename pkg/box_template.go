@@ -2,6 +2,8 @@ package pkg
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
@@ -12,21 +14,52 @@ type Box struct {
 	Right hclwrite.Tokens
 }
 
-func interfaces(tokens hclwrite.Tokens) []any {
-	result := make([]any, len(tokens))
-	for i, token := range tokens {
-		result[i] = token
+// BoxMarkers is the pair of comments that denotes where a box starts and ends.
+type BoxMarkers struct {
+	Open  string `json:"openMarker"`
+	Close string `json:"closeMarker"`
+}
+
+var DefaultBoxMarkers = BoxMarkers{
+	Open:  "/*<box>*/",
+	Close: "/*</box>*/",
+}
+
+// DefaultBoxTemplate denotes the box with the configured markers, so it works with any BoxMarkers.
+const DefaultBoxTemplate = `{{ .boxOpenMarker }} (var.{{ .toggleName }} ? {{ .boxCloseMarker }} { yor_trace = 123 } {{ .boxOpenMarker }} : {}) {{ .boxCloseMarker }}`
+
+// Validate ensures both markers are distinct inline comments, so they survive as single comment tokens.
+func (m BoxMarkers) Validate() error {
+	for _, marker := range []string{m.Open, m.Close} {
+		if !strings.HasPrefix(marker, "/*") || !strings.HasSuffix(marker, "*/") || len(marker) < 4 ||
+			strings.Contains(marker[2:len(marker)-2], "*/") {
+			return fmt.Errorf("box marker %q must be an inline comment like /*<box>*/", marker)
+		}
 	}
-	return result
+	if m.Open == m.Close {
+		return fmt.Errorf("box open marker and close marker must be different, got %q", m.Open)
+	}
+	return nil
 }
 
 func BuildBoxFromTemplate(template string) (Box, hcl.Diagnostics) {
+	return BuildBoxFromTemplateWithMarkers(template, DefaultBoxMarkers)
+}
+
+func BuildBoxFromTemplateWithMarkers(template string, markers BoxMarkers) (Box, hcl.Diagnostics) {
 	template = fmt.Sprintf("tags = %s", template)
 	f, diagnostics := hclwrite.ParseConfig([]byte(template), "", hcl.InitialPos)
 	if diagnostics.HasErrors() {
 		return Box{}, diagnostics
 	}
 	templateTokens := f.Body().GetAttribute("tags").BuildTokens(hclwrite.Tokens{})
+	if !containsComment(templateTokens, markers.Open) || !containsComment(templateTokens, markers.Close) {
+		return Box{}, hcl.Diagnostics{&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Box markers not found",
+			Detail:   fmt.Sprintf("box template must denote the box with %s and %s", markers.Open, markers.Close),
+		}}
+	}
 	leftTokens := hclwrite.Tokens{
 		&hclwrite.Token{
 			Type:  hclsyntax.TokenOParen,
@@ -40,9 +73,9 @@ func BuildBoxFromTemplate(template string) (Box, hcl.Diagnostics) {
 	for _, token := range templateTokens {
 		if token.Type == hclsyntax.TokenComment {
 			commentText := string(token.Bytes)
-			if commentText == "/*<box>*/" {
+			if commentText == markers.Open {
 				inBox = true
-			} else if commentText == "/*</box>*/" {
+			} else if commentText == markers.Close {
 				inBox = false
 				left = false
 			}
@@ -58,7 +91,7 @@ func BuildBoxFromTemplate(template string) (Box, hcl.Diagnostics) {
 	}
 	endToken := &hclwrite.Token{
 		Type:         hclsyntax.TokenComment,
-		Bytes:        []byte("/*</box>*/"),
+		Bytes:        []byte(markers.Close),
 		SpacesBefore: 1,
 	}
 	leftTokens = append(leftTokens, endToken)
@@ -70,3 +103,20 @@ func BuildBoxFromTemplate(template string) (Box, hcl.Diagnostics) {
 
 	return Box{Left: leftTokens, Right: rightTokens}, hcl.Diagnostics{}
 }
+
+// withoutParens returns the box without the pair of parens that wraps it.
+func (b Box) withoutParens() Box {
+	return Box{
+		Left:  b.Left[1:],
+		Right: b.Right[:len(b.Right)-1],
+	}
+}
+
+func containsComment(tokens hclwrite.Tokens, comment string) bool {
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment && string(token.Bytes) == comment {
+			return true
+		}
+	}
+	return false
+}
@@ -26,3 +26,14 @@ func TestParseBoxTemplate(t *testing.T) {
 	actual := fmt.Sprintf("tags = %s", string(newFile.Bytes()))
 	assert.Equal(t, formatHcl(t, `tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ var.dummy/*<box>*/ : {})/*</box>*/)`), formatHcl(t, actual))
 }
+
+func TestParseBoxTemplateWithCustomMarkers(t *testing.T) {
+	template := `/*<yorbox:trace>*/(var.yor_toggle ? /*</yorbox:trace>*/ { yor_trace = 123 } /*<yorbox:trace>*/ : {})/*</yorbox:trace>*/`
+	box, diagnostics := BuildBoxFromTemplateWithMarkers(template, BoxMarkers{Open: "/*<yorbox:trace>*/", Close: "/*</yorbox:trace>*/"})
+	require.False(t, diagnostics.HasErrors())
+	assert.Equal(t, "( /*<yorbox:trace>*/(var.yor_toggle ? /*</yorbox:trace>*/", string(box.Left.Bytes()))
+	assert.Equal(t, " /*<yorbox:trace>*/ : {}) /*</yorbox:trace>*/)", string(box.Right.Bytes()))
+
+	_, diagnostics = BuildBoxFromTemplate(template)
+	assert.True(t, diagnostics.HasErrors())
+}
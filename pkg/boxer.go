@@ -2,33 +2,74 @@ package pkg
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"io/fs"
+	"log/slog"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/ahmetb/go-linq/v3"
-	al "github.com/emirpasic/gods/lists/arraylist"
 	"github.com/emirpasic/gods/sets"
 	"github.com/emirpasic/gods/sets/hashset"
-	lls "github.com/emirpasic/gods/stacks/linkedliststack"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 )
 
-type tokensRange struct {
+// TokensRange is a range of tokens, Start and End are indexes of the first and the last token in the range.
+type TokensRange struct {
 	Start int
 	End   int
 }
 
+// Options is shared by all goroutines that process files, it must not be modified during processing.
 type Options struct {
 	Path                string
 	ToggleName          string
 	BoxTemplate         string
 	TagsPrefix          string
 	IgnoreResourceTypes sets.Set
+	BoxMarkers          BoxMarkers
+	Layers              []BoxLayer
+	KnownTemplates      []string
+	KnownToggleNames    []string
+	Force               bool
+	Check               bool
+	SplitGitTags        bool
+	GitToggleName       string
+	GitBoxTemplate      string
+	StripTags           []string
+	RedactTags          []string
+	RedactTemplate      string
+	// OmitParens boxes tags without wrapping the box with an extra pair of parens.
+	OmitParens bool
+	// Structural recognises boxes by matching the structure of the expression against the box template, so boxes whose
+	// comment markers have been stripped could still be replaced.
+	Structural bool
+	// Repair reconstructs boxes of tags attributes whose box markers are unbalanced, such attributes are left as is
+	// and reported as errors if it's false.
+	Repair bool
+	// Logger receives decisions made while boxing, nothing is logged if it's nil.
+	Logger *slog.Logger
+	// Parallelism is the number of files processed concurrently, GOMAXPROCS is used if it's not positive.
+	Parallelism int
+	// TagDetector finds tags to box, NewYorTagDetector is used if it's nil.
+	TagDetector TagDetector
+	// BoxRenderer renders boxes for tags found by TagDetector, NewTemplateBoxRenderer is used if it's nil.
+	BoxRenderer BoxRenderer
+	// FS is the file system that Path and other paths refer to, the OS file system is used if it's nil.
+	FS fs.FS
+	// Writer writes changed files. If it's nil, FS is used when it implements FileWriter, or the OS file system when
+	// FS is nil.
+	Writer FileWriter
 }
 
 func NewOptions(path, toggleName, boxTemplate, tagsPrefix string, ignoreResourceTypes []string) Options {
@@ -36,7 +77,7 @@ func NewOptions(path, toggleName, boxTemplate, tagsPrefix string, ignoreResource
 		toggleName = "yor_toggle"
 	}
 	if boxTemplate == "" {
-		boxTemplate = `/*<box>*/ (var.{{ .toggleName }} ? /*</box>*/ { yor_trace = 123 } /*<box>*/ : {}) /*</box>*/`
+		boxTemplate = DefaultBoxTemplate
 	}
 
 	opts := Options{
@@ -45,6 +86,10 @@ func NewOptions(path, toggleName, boxTemplate, tagsPrefix string, ignoreResource
 		BoxTemplate:         boxTemplate,
 		TagsPrefix:          tagsPrefix,
 		IgnoreResourceTypes: hashset.New(),
+		BoxMarkers:          DefaultBoxMarkers,
+		GitToggleName:       "yor_git_toggle",
+		GitBoxTemplate:      DefaultGitBoxTemplate,
+		RedactTemplate:      defaultRedactTemplate,
 	}
 	for _, t := range ignoreResourceTypes {
 		opts.IgnoreResourceTypes.Add(t)
@@ -52,174 +97,836 @@ func NewOptions(path, toggleName, boxTemplate, tagsPrefix string, ignoreResource
 	return opts
 }
 
+func (o Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return discardLogger
+	}
+	return o.Logger
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 func (o Options) RenderBoxTemplate() (string, error) {
-	return o.renderBoxTemplate(o.BoxTemplate)
+	return o.renderBoxTemplate(o.BoxTemplate, o.BoxMarkers)
+}
+
+func (o Options) BuildBox() (Box, hcl.Diagnostics) {
+	return o.buildLayerBox(BoxLayer{BoxTemplate: o.BoxTemplate, BoxMarkers: o.BoxMarkers})
+}
+
+func (o Options) renderBoxTemplate(tpl string, markers BoxMarkers) (string, error) {
+	return o.renderTemplate("Box", tpl, map[string]any{
+		"boxOpenMarker":  markers.Open,
+		"boxCloseMarker": markers.Close,
+	})
+}
+
+// renderTemplate renders the template with variables derived from options, plus the extra variables.
+func (o Options) renderTemplate(name, tpl string, extraVars map[string]any) (string, error) {
+	return o.executeTemplate(template.New(name), tpl, extraVars)
 }
 
-func (o Options) renderBoxTemplate(tpl string) (string, error) {
-	t := template.Must(template.New("Box").Funcs(sprig.TxtFuncMap()).Parse(tpl))
+// executeTemplate parses tpl into t and renders it with variables derived from options, plus the extra variables.
+func (o Options) executeTemplate(t *template.Template, tpl string, extraVars map[string]any) (string, error) {
+	t, err := t.Funcs(sprig.TxtFuncMap()).Parse(tpl)
+	if err != nil {
+		return "", err
+	}
 	vars := map[string]any{
-		"dirPath":    o.Path,
-		"toggleName": o.ToggleName,
-		"tagsPrefix": o.TagsPrefix,
+		"dirPath":       o.Path,
+		"toggleName":    o.ToggleName,
+		"tagsPrefix":    o.TagsPrefix,
+		"gitToggleName": o.GitToggleName,
+	}
+	for k, v := range extraVars {
+		vars[k] = v
 	}
 
 	buff := &bytes.Buffer{}
-	err := t.Execute(buff, vars)
+	err = t.Execute(buff, vars)
 	if err != nil {
 		return "", err
 	}
 	return buff.String(), nil
 }
 
+// FileResult is the outcome of processing a single file.
+type FileResult struct {
+	Path string `json:"path"`
+	// Modified is true when boxing changed the file, it has been written back unless Options.Check is set.
+	Modified bool          `json:"modified"`
+	Blocks   []BlockResult `json:"blocks"`
+}
+
+// Diagnostics returns errors of attributes that have been left as is, e.g. attributes with unbalanced box markers.
+func (r FileResult) Diagnostics() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, block := range r.Blocks {
+		if block.diagnostic != nil {
+			diags = append(diags, block.diagnostic)
+		}
+	}
+	return diags
+}
+
+// resultsDiagnostics returns diagnostics of all results as an error, or nil if there is none.
+func resultsDiagnostics(results []FileResult) error {
+	var diags hcl.Diagnostics
+	for _, r := range results {
+		diags = append(diags, r.Diagnostics()...)
+	}
+	if len(diags) == 0 {
+		return nil
+	}
+	return diags
+}
+
 func ProcessDirectory(options Options) error {
-	path := options.Path
-	files, err := os.ReadDir(path)
+	return ProcessDirectoryContext(context.Background(), options)
+}
+
+// ProcessDirectoryContext is ProcessDirectory that stops when ctx is done, see ProcessFilesContext. Diagnostics of
+// attributes that have been left as is are returned as an error after all files have been processed.
+func ProcessDirectoryContext(ctx context.Context, options Options) error {
+	options.logger().Info("processing directory", "path", options.Path)
+	results, err := ProcessFilesContext(ctx, []string{options.Path}, options)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
+	return resultsDiagnostics(results)
+}
 
-	for _, file := range files {
-		if file.IsDir() || filepath.Ext(file.Name()) != ".tf" {
+// CanceledError is returned when processing stops because the context is done. Files that have been processed are
+// written as usual, files listed in Unprocessed are left as they were.
+type CanceledError struct {
+	Unprocessed []string
+	Err         error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("%s, %d files not processed: %s", e.Err, len(e.Unprocessed), strings.Join(e.Unprocessed, ", "))
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessFiles boxes the given files, directories are expanded to the .tf files directly under them. All paths
+// are checked before any file is processed, an error is returned if a path doesn't exist or isn't a .tf file.
+// Only files that have been changed by boxing are written.
+//
+// Files are processed by Options.Parallelism workers, results are returned in the same order as the files. Files are
+// written in the same order too, so if any file fails, files after it are left as they were, and the results of files
+// before the first failed one are returned along with its error. An attribute that can't be boxed, e.g. with unbalanced
+// box markers, doesn't fail its file, it's left as is and reported by FileResult.Diagnostics.
+func ProcessFiles(paths []string, options Options) ([]FileResult, error) {
+	return ProcessFilesContext(context.Background(), paths, options)
+}
+
+// ProcessFilesContext is ProcessFiles that stops when ctx is done. It checks ctx before every file, and between
+// blocks of a file, a file is never written partially. The results of processed files are returned along with a
+// *CanceledError that lists the files that have not been processed, unless any file has failed.
+func ProcessFilesContext(ctx context.Context, paths []string, options Options) ([]FileResult, error) {
+	files, err := expandPaths(options.fileSystem(), paths)
+	if err != nil {
+		return nil, err
+	}
+	boxer, err := NewBoxer(options)
+	if err != nil {
+		return nil, err
+	}
+	canceled := func(err error) bool {
+		return ctx.Err() != nil && errors.Is(err, ctx.Err())
+	}
+	results := make([]FileResult, len(files))
+	errs := make([]error, len(files))
+	// settled[i] is closed once files[i] and all files before it have been processed or skipped, failed is set before
+	// that if any of them has failed.
+	settled := make([]chan struct{}, len(files))
+	for i := range settled {
+		settled[i] = make(chan struct{})
+	}
+	var failed atomic.Bool
+	waitPrevious := func(i int) {
+		if i > 0 {
+			<-settled[i-1]
+		}
+	}
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < options.parallelism(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// files after a failed one are neither processed nor returned
+				if !failed.Load() {
+					if errs[i] = ctx.Err(); errs[i] == nil {
+						results[i], errs[i] = boxer.processFile(ctx, files[i], func() bool {
+							waitPrevious(i)
+							return !failed.Load()
+						})
+					}
+				}
+				waitPrevious(i)
+				if errs[i] != nil && !canceled(errs[i]) {
+					failed.Store(true)
+				}
+				close(settled[i])
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var processed []FileResult
+	var unprocessed []string
+	for i, filePath := range files {
+		if canceled(errs[i]) {
+			unprocessed = append(unprocessed, filePath)
 			continue
 		}
+		if errs[i] != nil {
+			options.logger().Error("failed to process file", "file", filePath, "error", errs[i])
+			return processed, errs[i]
+		}
+		options.logger().Info("processed file", "file", filePath, "modified", results[i].Modified, "check", options.Check)
+		processed = append(processed, results[i])
+	}
+	if len(unprocessed) > 0 {
+		options.logger().Warn("processing canceled", "unprocessed", len(unprocessed), "error", ctx.Err())
+		return processed, &CanceledError{Unprocessed: unprocessed, Err: ctx.Err()}
+	}
+	return results, nil
+}
 
-		filePath := filepath.Join(path, file.Name())
+func (o Options) parallelism() int {
+	if o.Parallelism > 0 {
+		return o.Parallelism
+	}
+	return runtime.GOMAXPROCS(0)
+}
 
-		// Read the file contents
-		data, err := os.ReadFile(filePath)
+func expandPaths(fsys fs.FS, paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := fs.Stat(fsys, path)
 		if err != nil {
-			panic(err.Error())
+			return nil, err
+		}
+		if info.IsDir() {
+			dirFiles, err := terraformFiles(fsys, path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, dirFiles...)
+			continue
+		}
+		if filepath.Ext(path) != ".tf" {
+			return nil, fmt.Errorf("%s is not a Terraform HCL file, only .tf files could be boxed", path)
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// processFile boxes the file and writes it if it's changed. ready is called right before writing, the file is left as
+// is if it returns false.
+func (b *Boxer) processFile(ctx context.Context, filePath string, ready func() bool) (FileResult, error) {
+	result := FileResult{Path: filePath}
+	fsys := b.options.fileSystem()
+	// Read the file contents
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return result, err
+	}
+
+	boxed, blocks, err := b.rewriteBytes(ctx, data, filePath, b.boxAttribute)
+	if err != nil {
+		return result, err
+	}
+	result.Blocks = blocks
+	if bytes.Equal(data, boxed) {
+		return result, nil
+	}
+
+	result.Modified = true
+	if b.options.Check || !ready() {
+		return result, nil
+	}
+
+	info, err := fs.Stat(fsys, filePath)
+	if err != nil {
+		return result, err
+	}
+	// Write the updated file contents back to the file
+	return result, b.options.fileWriter().WriteFile(filePath, boxed, info.Mode().Perm())
+}
+
+// ProcessStream reads HCL from in, boxes it and writes the result to out, filename is used in diagnostics only.
+func ProcessStream(in io.Reader, out io.Writer, filename string, options Options) error {
+	boxer, err := NewBoxer(options)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	boxed, blocks, err := boxer.boxBytes(data, filename)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(boxed); err != nil {
+		return err
+	}
+	return resultsDiagnostics([]FileResult{{Path: filename, Blocks: blocks}})
+}
+
+func (b *Boxer) boxBytes(data []byte, filename string) ([]byte, []BlockResult, error) {
+	return b.rewriteBytes(context.Background(), data, filename, b.boxAttribute)
+}
+
+// rewriteBytes calls rewrite for every resource and module block in data, the original data is returned if no tags
+// attribute has been changed. Attributes that have been left as is because of errors, e.g. unbalanced box markers, get
+// diagnostics in their results, other attributes are rewritten as usual.
+func (b *Boxer) rewriteBytes(ctx context.Context, data []byte, filename string, rewrite func(*hclwrite.Block, string) (BlockResult, bool)) ([]byte, []BlockResult, error) {
+	// Parse the file to *hclwrite.File
+	f, diag := hclwrite.ParseConfig(data, filename, hcl.InitialPos)
+	if diag.HasErrors() {
+		return nil, nil, diag
+	}
+
+	blocks, err := b.rewriteFile(ctx, f, rewrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := fillSourceRanges(blocks, data, filename, b.options); err != nil {
+		return nil, nil, err
+	}
+	changed := false
+	for i, block := range blocks {
+		if block.Action == ActionCorrupted {
+			blocks[i].diagnostic = corruptionDiagnostic(data, filename, block)
+		} else if block.err != nil {
+			blocks[i].diagnostic = &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Failed to sanitize tags",
+				Detail:   fmt.Sprintf("%s of %s is left as is: %s", block.Attribute, block.Address, block.err),
+				Subject:  block.Range.Ptr(),
+			}
 		}
+		changed = changed || block.Action.changed()
+	}
+	if changed {
+		return f.Bytes(), blocks, nil
+	}
+	// Keep the original content so that files without boxing changes won't be reformatted
+	return data, blocks, nil
+}
 
-		// Parse the file to *hclwrite.File
-		f, diag := hclwrite.ParseConfig(data, file.Name(), hcl.InitialPos)
-		if diag.HasErrors() {
-			return diag
+// terraformFiles lists paths of all .tf files directly under the directory.
+func terraformFiles(fsys fs.FS, dir string) ([]string, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".tf" {
+			continue
 		}
+		paths = append(paths, joinPath(fsys, dir, file.Name()))
+	}
+	return paths, nil
+}
 
-		// Invoke BoxFile function
-		BoxFile(f, options)
+// Boxer boxes tags with boxes rendered from the templates of Options, templates are rendered and parsed only
+// once when the Boxer is created. A Boxer could be shared by goroutines.
+type Boxer struct {
+	options  Options
+	box      Box
+	gitBox   Box
+	detector TagDetector
+	renderer BoxRenderer
+}
 
-		// Write the updated file contents back to the file
-		err = os.WriteFile(filePath, f.Bytes(), os.ModePerm)
-		if err != nil {
-			panic(err.Error())
+func NewBoxer(options Options) (*Boxer, error) {
+	renderer, err := newTemplateBoxRenderer(options)
+	if err != nil {
+		return nil, err
+	}
+	boxer := &Boxer{
+		options:  options,
+		box:      renderer.box,
+		gitBox:   renderer.gitBox,
+		detector: options.TagDetector,
+		renderer: options.BoxRenderer,
+	}
+	if boxer.detector == nil {
+		boxer.detector = NewYorTagDetector(options)
+	}
+	if boxer.renderer == nil {
+		boxer.renderer = renderer
+	}
+	return boxer, nil
+}
+
+// Change describes a tags attribute that has been changed, Range refers to the source before the change.
+type Change struct {
+	Address   string
+	Attribute string
+	Action    Action
+	Range     hcl.Range
+}
+
+// BoxBytes boxes yor tags in src, the returned bytes are src itself if nothing has been changed. A tags attribute with
+// unbalanced box markers that can't be repaired is left as is and reported by the diagnostics, other attributes are
+// still boxed.
+func (b *Boxer) BoxBytes(src []byte, filename string) ([]byte, []Change, hcl.Diagnostics) {
+	return changeSet(b.boxBytes(src, filename))
+}
+
+func changeSet(dst []byte, blocks []BlockResult, err error) ([]byte, []Change, hcl.Diagnostics) {
+	if err != nil {
+		if diags, ok := err.(hcl.Diagnostics); ok {
+			return dst, nil, diags
 		}
+		return dst, nil, hcl.Diagnostics{&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  err.Error(),
+		}}
 	}
-	return nil
+	var changes []Change
+	var diags hcl.Diagnostics
+	for _, block := range blocks {
+		if block.diagnostic != nil {
+			diags = append(diags, block.diagnostic)
+		}
+		if block.Action.changed() {
+			changes = append(changes, Change{
+				Address:   block.Address,
+				Attribute: block.Attribute,
+				Action:    block.Action,
+				Range:     block.Range,
+			})
+		}
+	}
+	return dst, changes, diags
 }
 
 func BoxFile(file *hclwrite.File, option Options) {
-	for _, block := range file.Body().Blocks() {
-		if block.Type() != "resource" && block.Type() != "module" {
-			continue
+	boxer, err := NewBoxer(option)
+	if err != nil {
+		option.logger().Error("failed to build box from template", "error", err)
+		return
+	}
+	boxer.BoxFile(file)
+}
+
+func (b *Boxer) BoxFile(file *hclwrite.File) {
+	b.boxFile(file)
+}
+
+func (b *Boxer) boxFile(file *hclwrite.File) []BlockResult {
+	results, _ := b.rewriteFile(context.Background(), file, b.boxAttribute)
+	return results
+}
+
+// rewriteFile calls rewrite for every attribute to box of every block, see Boxer.attributes. Results of attributes
+// that exist are returned. ctx is checked before every block, the error of ctx is returned if it's done.
+func (b *Boxer) rewriteFile(ctx context.Context, file *hclwrite.File, rewrite func(*hclwrite.Block, string) (BlockResult, bool)) ([]BlockResult, error) {
+	var results []BlockResult
+	for i, block := range file.Body().Blocks() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, name := range b.attributes(block) {
+			if result, ok := rewrite(block, name); ok {
+				result.blockIndex = i
+				results = append(results, result)
+			}
 		}
-		boxTagsTokensForBlock(block, option)
 	}
+	return results, nil
+}
+
+// attributes returns names of attributes of the block that should be boxed, the `tags` attribute of resource and
+// module blocks unless the TagDetector implements AttributeSelector.
+func (b *Boxer) attributes(block *hclwrite.Block) []string {
+	if selector, ok := b.detector.(AttributeSelector); ok {
+		return selector.Attributes(block)
+	}
+	if block.Type() != "resource" && block.Type() != "module" {
+		b.options.logger().Debug("skipping block that is neither a resource nor a module", "type", block.Type(), "labels", block.Labels())
+		return nil
+	}
+	return []string{"tags"}
+}
+
+// tagsAttribute returns the `tags` attribute of resource and module blocks, or nil. The returned action is not
+// empty if the attribute must be left as is.
+func tagsAttribute(block *hclwrite.Block, option Options) (*hclwrite.Attribute, Action) {
+	if block.Type() != "resource" && block.Type() != "module" {
+		return nil, ""
+	}
+	return blockAttribute(block, "tags", option)
 }
 
-func boxTagsTokensForBlock(block *hclwrite.Block, option Options) {
+// blockAttribute returns the attribute of the block, or nil. The returned action is not empty if the attribute must
+// be left as is.
+func blockAttribute(block *hclwrite.Block, name string, option Options) (*hclwrite.Attribute, Action) {
+	attr := block.Body().GetAttribute(name)
+	if attr == nil {
+		return nil, ""
+	}
 	if block.Type() == "resource" && option.IgnoreResourceTypes.Contains(block.Labels()[0]) {
-		return
+		return attr, ActionIgnoredByType
+	}
+	for _, token := range attr.BuildTokens(nil) {
+		if token.Type == hclsyntax.TokenComment && strings.Contains(string(token.Bytes), ignoreAnnotation) {
+			return attr, ActionIgnoredByAnnotation
+		}
+	}
+	return attr, ""
+}
+
+// blockAddress returns the Terraform address of the block, like `aws_s3_bucket.this` or `module.vpc`.
+func blockAddress(block *hclwrite.Block) string {
+	if block.Type() == "resource" {
+		return strings.Join(block.Labels(), ".")
 	}
-	tags := block.Body().GetAttribute("tags")
+	return strings.Join(append([]string{block.Type()}, block.Labels()...), ".")
+}
+
+func boxTagsTokensForBlock(block *hclwrite.Block, option Options) (BlockResult, bool) {
+	boxer, err := NewBoxer(option)
+	if err != nil {
+		option.logger().Error("failed to build box from template", "error", err)
+		return BlockResult{}, false
+	}
+	return boxer.boxAttribute(block, "tags")
+}
+
+func (b *Boxer) boxAttribute(block *hclwrite.Block, name string) (BlockResult, bool) {
+	option := b.options
+	logger := option.logger().With("block", blockAddress(block), "attribute", name)
+	tags, result, ok := b.attributeToRewrite(block, name, logger)
 	if tags == nil {
-		return
+		return result, ok
 	}
 
-	tokens := tags.Expr().BuildTokens(hclwrite.Tokens{})
-	tokens = removeYorToggles(tokens)
-	output := al.New()
-	for _, token := range tokens {
-		output.Add(token)
+	originalTokens := expressionTokens(tags)
+	tokens, removal, corruption := b.removeBoxes(block, originalTokens, logger)
+	if corruption != nil {
+		result.Action = ActionCorrupted
+		result.corruption = corruption
+		return result, true
+	}
+	tokens, err := sanitizeTags(tokens, b.detect(block, tokens), option)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("leaving %s attribute as is", name), "error", err)
+		result.Action = ActionUnchanged
+		result.err = err
+		return result, true
+	}
+	if option.SplitGitTags {
+		tokens = splitGitTags(tokens, b.detect(block, tokens), option)
 	}
 	tokensWithOutToggle := tokens
-	yorTagsRanges := scanYorTagsRanges(tokensWithOutToggle, option)
-	linq.From(yorTagsRanges).OrderByDescending(func(i interface{}) interface{} {
-		return i.(tokensRange).End
-	}).ToSlice(&yorTagsRanges)
-	tplt, _ := option.RenderBoxTemplate()
-	boxTemplate, _ := BuildBoxFromTemplate(tplt)
+	yorTagsRanges := b.detect(block, tokensWithOutToggle)
 	for _, r := range yorTagsRanges {
-		output.Insert(r.End+1, interfaces(boxTemplate.Right)...)
-		output.Insert(r.Start, interfaces(boxTemplate.Left)...)
+		if tokensWithOutToggle[r.Start].Type != hclsyntax.TokenOBrace {
+			continue
+		}
+		for _, item := range objectItems(tokensWithOutToggle, r) {
+			result.YorKeys = append(result.YorKeys, item.Key)
+		}
+	}
+	if len(yorTagsRanges) == 0 {
+		logger.Debug("no tags map with yor_trace, yor_name or git_commit key found", "prefix", option.TagsPrefix)
+	}
+	tokens = spliceBoxes(tokensWithOutToggle, yorTagsRanges, func(r TokensRange) Box {
+		return b.renderer.Render(block, tokensWithOutToggle, r)
+	})
+	result.Action = boxingAction(originalTokens, tokens, option)
+	if removal == ActionRepaired || (removal == ActionReBoxed && result.Action == ActionBoxed) {
+		result.Action = removal
+	}
+	logger.Debug(fmt.Sprintf("processed %s attribute", name), "action", result.Action, "yorTagsMaps", len(yorTagsRanges), "yorKeys", result.YorKeys)
+	if result.Action == ActionUnchanged {
+		return result, true
+	}
+	setExpressionTokens(block.Body(), name, tags, tokens)
+	return result, true
+}
+
+// detect returns ranges found by the TagDetector in tokens of an attribute of the block, sorted by Start. Ranges that
+// are out of tokens or overlap others are dropped, so a misbehaving TagDetector can't corrupt the attribute.
+func (b *Boxer) detect(block *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange {
+	return normalizeRanges(b.detector.Detect(block, tokens), len(tokens))
+}
+
+// attributeToRewrite returns the attribute of the block to rewrite. If it's nil, the returned result and bool should
+// be returned by the rewrite function as is.
+func (b *Boxer) attributeToRewrite(block *hclwrite.Block, name string, logger *slog.Logger) (*hclwrite.Attribute, BlockResult, bool) {
+	attr, action := blockAttribute(block, name, b.options)
+	if attr == nil {
+		logger.Debug(fmt.Sprintf("skipping block without %s attribute", name))
+		return nil, BlockResult{}, false
+	}
+	result := BlockResult{
+		Address:   blockAddress(block),
+		Attribute: name,
+		Action:    action,
+	}
+	if action != "" {
+		logger.Debug(fmt.Sprintf("skipping ignored %s attribute", name), "action", action)
+		return nil, result, true
+	}
+	return attr, result, true
+}
+
+// removeBoxes removes boxes of all layers from tokens of a tags expression. The returned action is ActionRepaired if
+// box markers were unbalanced and the boxes have been repaired, ActionReBoxed if any box has been recognised
+// structurally, or empty. The corruption is not nil if box markers are unbalanced and can't be repaired.
+func (b *Boxer) removeBoxes(block *hclwrite.Block, tokens hclwrite.Tokens, logger *slog.Logger) (hclwrite.Tokens, Action, *markerCorruption) {
+	option := b.options
+	var action Action
+	if corruption := checkBoxMarkers(tokens, option.boxMarkers()); corruption != nil {
+		repaired := false
+		if option.Repair {
+			tokens, repaired = b.repair(block, tokens)
+		}
+		if !repaired {
+			logger.Warn("leaving tags attribute with unbalanced box markers as is", "detail", corruption.Detail)
+			return nil, "", corruption
+		}
+		logger.Debug("repaired tags attribute with unbalanced box markers", "detail", corruption.Detail)
+		action = ActionRepaired
+	}
+	for _, layer := range option.BoxLayers() {
+		tokens = removeYorToggles(tokens, layer.BoxMarkers)
 	}
-	tokens = toTokens(output)
-	block.Body().SetAttributeRaw("tags", tokens)
+	if option.SplitGitTags {
+		tokens = removeYorToggles(tokens, option.gitLayer().BoxMarkers)
+	}
+	if option.Structural && action == "" {
+		var removed bool
+		if tokens, removed = b.removeStructuralBoxes(block, tokens); removed {
+			action = ActionReBoxed
+		}
+	}
+	return tokens, action, nil
 }
 
-func scanYorTagsRanges(tokens hclwrite.Tokens, option Options) []tokensRange {
-	ranges := make([]tokensRange, 0)
-	latestOBrace := lls.New()
-	var previousYorTraceKey bool
-	yorTags := false
+// expressionTokens returns all tokens between `=` and the end of the attribute. Unlike Expr().BuildTokens, comments
+// leading or trailing the expression, like `tags = /*<box>*/ var.x ? ...`, are included since hclwrite doesn't treat
+// them as part of the expression.
+func expressionTokens(attr *hclwrite.Attribute) hclwrite.Tokens {
+	tokens := attr.BuildTokens(nil)
+	start := 0
 	for i, token := range tokens {
-		switch token.Type {
-		case hclsyntax.TokenNewline:
+		if token.Type == hclsyntax.TokenEqual {
+			start = i + 1
+			break
+		}
+	}
+	end := len(tokens)
+	// line comments like `# comment` end with a newline, they're left with the attribute
+	for end > start && (tokens[end-1].Type == hclsyntax.TokenNewline || bytes.HasSuffix(tokens[end-1].Bytes, []byte("\n"))) {
+		end--
+	}
+	return tokens[start:end]
+}
+
+// setExpressionTokens replaces all tokens returned by expressionTokens with tokens. Comments around the expression are
+// kept by SetAttributeRaw, so they're blanked in place to avoid duplicating them.
+func setExpressionTokens(body *hclwrite.Body, name string, attr *hclwrite.Attribute, tokens hclwrite.Tokens) {
+	tokens = cloneTokens(tokens)
+	expr := make(map[*hclwrite.Token]bool)
+	for _, token := range attr.Expr().BuildTokens(nil) {
+		expr[token] = true
+	}
+	for _, token := range expressionTokens(attr) {
+		if !expr[token] {
+			token.Type = hclsyntax.TokenNil
+			token.Bytes = nil
+			token.SpacesBefore = 0
+		}
+	}
+	body.SetAttributeRaw(name, tokens)
+}
+
+// isYorKey returns true for keys that only exist in tags generated by yor.
+func (o Options) isYorKey(name string) bool {
+	return name == fmt.Sprintf("%syor_name", o.TagsPrefix) ||
+		name == fmt.Sprintf("%syor_trace", o.TagsPrefix) ||
+		name == fmt.Sprintf("%sgit_commit", o.TagsPrefix)
+}
+
+// scanYorTagsRanges parses tokens of an expression, or of an attribute, with hclsyntax and returns token ranges of the
+// outermost object constructors that contain yor keys.
+func scanYorTagsRanges(tokens hclwrite.Tokens, option Options) []TokensRange {
+	return expressionTokensRanges(tokens, func(expr hclsyntax.Expression) []hcl.Range {
+		return yorObjectRanges(expr, option)
+	})
+}
+
+// expressionTokensRanges parses tokens of an expression, or of an attribute, with hclsyntax, and maps source ranges
+// returned by find back to token ranges. Ranges nested in others are dropped, the returned ranges are sorted.
+func expressionTokensRanges(tokens hclwrite.Tokens, find func(hclsyntax.Expression) []hcl.Range) []TokensRange {
+	return append(make([]TokensRange, 0), normalizeRanges(tokensRanges(tokens, find), len(tokens))...)
+}
+
+// expressionNodeRanges returns token ranges of all expressions parsed from tokens, nested ones included.
+func expressionNodeRanges(tokens hclwrite.Tokens) map[TokensRange]bool {
+	result := make(map[TokensRange]bool)
+	for _, r := range tokensRanges(tokens, func(expr hclsyntax.Expression) []hcl.Range {
+		var ranges []hcl.Range
+		_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+			ranges = append(ranges, node.Range())
+			return nil
+		})
+		return ranges
+	}) {
+		result[r] = true
+	}
+	return result
+}
+
+// tokensRanges parses tokens of an expression, or of an attribute, with hclsyntax, and maps source ranges returned by
+// find back to token ranges, ranges that don't start and end at boundaries of tokens are dropped.
+func tokensRanges(tokens hclwrite.Tokens, find func(hclsyntax.Expression) []hcl.Range) []TokensRange {
+	exprTokens := tokens
+	if len(tokens) > 1 && tokens[0].Type == hclsyntax.TokenIdent && tokens[1].Type == hclsyntax.TokenEqual {
+		exprTokens = tokens[2:]
+	}
+	expr, diag := hclsyntax.ParseExpression(exprTokens.Bytes(), "", hcl.InitialPos)
+	if diag.HasErrors() {
+		return nil
+	}
+	offset := len(tokens) - len(exprTokens)
+	starts := make(map[int]int)
+	ends := make(map[int]int)
+	position := 0
+	for i, token := range exprTokens {
+		position += token.SpacesBefore
+		starts[position] = i + offset
+		position += len(token.Bytes)
+		ends[position] = i + offset
+	}
+	var found []TokensRange
+	for _, r := range find(expr) {
+		start, okStart := starts[r.Start.Byte]
+		end, okEnd := ends[r.End.Byte]
+		if okStart && okEnd {
+			found = append(found, TokensRange{Start: start, End: end})
+		}
+	}
+	return found
+}
+
+// normalizeRanges sorts ranges by Start and drops ranges that are out of tokens of the given length, or overlap a range
+// before them, e.g. nested in it, which would be boxed as a whole.
+func normalizeRanges(ranges []TokensRange, length int) []TokensRange {
+	linq.From(ranges).Where(func(i interface{}) bool {
+		r := i.(TokensRange)
+		return r.Start >= 0 && r.Start <= r.End && r.End < length
+	}).OrderBy(func(i interface{}) interface{} {
+		return i.(TokensRange).Start
+	}).ThenByDescending(func(i interface{}) interface{} {
+		return i.(TokensRange).End
+	}).ToSlice(&ranges)
+	var result []TokensRange
+	for _, r := range ranges {
+		if len(result) > 0 && r.Start <= result[len(result)-1].End {
 			continue
-		case hclsyntax.TokenQuotedLit:
-			fallthrough
-		case hclsyntax.TokenIdent:
-			name := string(token.Bytes)
-			previousYorTraceKey = name == fmt.Sprintf("%syor_name", option.TagsPrefix) ||
-				name == fmt.Sprintf("%syor_trace", option.TagsPrefix) ||
-				name == fmt.Sprintf("%sgit_commit", option.TagsPrefix)
-		case hclsyntax.TokenEqual:
-			fallthrough
-		case hclsyntax.TokenColon:
-			// we're sure `yor_trace =` or `git_commit =` or `yor_trace:` or `git_commit:`
-			if previousYorTraceKey {
-				yorTags = true
-			}
-		case hclsyntax.TokenOBrace:
-			latestOBrace.Push(i)
-		case hclsyntax.TokenCBrace:
-			start, _ := latestOBrace.Pop()
-			if yorTags {
-				ranges = append(ranges, tokensRange{Start: start.(int), End: i})
-				yorTags = false
-			}
-		default:
 		}
+		result = append(result, r)
 	}
-	return ranges
+	return result
 }
 
-func removeYorToggles(tokens hclwrite.Tokens) hclwrite.Tokens {
+func removeYorToggles(tokens hclwrite.Tokens, markers BoxMarkers) hclwrite.Tokens {
 	result := hclwrite.Tokens{}
 	inBox := false
+	depth := 0
+	// depths of the parens wrapping boxes, they're removed along with the boxes
+	var boxParens []int
 	for i := 0; i < len(tokens); i++ {
-		if tokens[i].Type == hclsyntax.TokenOParen && i < len(tokens)-1 && tokens[i+1].Type == hclsyntax.TokenComment {
-			if string(tokens[i+1].Bytes) == "/*<box>*/" {
+		if tokens[i].Type == hclsyntax.TokenOParen && i < len(tokens)-1 && tokens[i+1].Type == hclsyntax.TokenComment && !isCallParen(tokens, i) {
+			if string(tokens[i+1].Bytes) == markers.Open {
+				boxParens = append(boxParens, depth)
 				inBox = true
 				continue
 			}
 		}
 		if tokens[i].Type == hclsyntax.TokenComment {
-			if string(tokens[i].Bytes) == "/*<box>*/" {
+			if string(tokens[i].Bytes) == markers.Open {
 				inBox = true
 				continue
-			} else if string(tokens[i].Bytes) == "/*</box>*/" {
+			} else if string(tokens[i].Bytes) == markers.Close {
 				inBox = false
-				if i < len(tokens)-1 && tokens[i+1].Type == hclsyntax.TokenCParen {
+				if i < len(tokens)-1 && tokens[i+1].Type == hclsyntax.TokenCParen && len(boxParens) > 0 && boxParens[len(boxParens)-1] == depth {
+					boxParens = boxParens[:len(boxParens)-1]
 					i++
 				}
 				continue
 			}
 		}
-		if !inBox {
-			result = append(result, tokens[i])
+		if inBox {
+			continue
+		}
+		switch tokens[i].Type {
+		case hclsyntax.TokenOParen:
+			depth++
+		case hclsyntax.TokenCParen:
+			depth--
+		default:
 		}
+		result = append(result, tokens[i])
+	}
+	return result
+}
+
+// isCallParen returns true if the paren at index i opens the arguments of a function call, like `merge(`.
+func isCallParen(tokens hclwrite.Tokens, i int) bool {
+	if i == 0 || tokens[i-1].Type != hclsyntax.TokenIdent {
+		return false
+	}
+	keyword := string(tokens[i-1].Bytes)
+	return keyword != "in" && keyword != "if"
+}
+
+func cloneTokens(tokens hclwrite.Tokens) hclwrite.Tokens {
+	result := make(hclwrite.Tokens, len(tokens))
+	for i, token := range tokens {
+		clone := *token
+		result[i] = &clone
 	}
 	return result
 }
 
-func toTokens(l *al.List) hclwrite.Tokens {
-	tokens := hclwrite.Tokens{}
-	it := l.Iterator()
-	for it.Next() {
-		tokens = append(tokens, it.Value().(*hclwrite.Token))
+// spliceBoxes wraps every range with the box returned by boxFor in a single pass, ranges must be sorted by Start. A
+// range that overlaps a range before it, e.g. nested in it, is skipped since it's boxed already.
+func spliceBoxes(tokens hclwrite.Tokens, ranges []TokensRange, boxFor func(TokensRange) Box) hclwrite.Tokens {
+	result := make(hclwrite.Tokens, 0, len(tokens))
+	next := 0
+	for _, r := range ranges {
+		if r.Start < next {
+			continue
+		}
+		box := boxFor(r)
+		result = append(result, tokens[next:r.Start]...)
+		// Tokens are cloned since formatting modifies them in place
+		result = append(result, cloneTokens(box.Left)...)
+		result = append(result, tokens[r.Start:r.End+1]...)
+		result = append(result, cloneTokens(box.Right)...)
+		next = r.End + 1
 	}
-	return tokens
+	return append(result, tokens[next:]...)
 }
@@ -1,9 +1,18 @@
 package pkg
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/emirpasic/gods/lists/arraylist"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -175,7 +184,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
 	inputs := []struct {
 		name string
 		code string
-		want []tokensRange
+		want []TokensRange
 	}{
 		{
 			name: "single yor_trace tag",
@@ -188,7 +197,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
             }  
         }  
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 2, End: 16},
 			},
 		},
@@ -204,7 +213,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
 			})
         }  
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 4, End: 12},
 			},
 		},
@@ -219,7 +228,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
             }  
         }  
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 2, End: 20},
 			},
 		},
@@ -235,7 +244,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
 			})
         }  
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 14, End: 22},
 			},
 		},
@@ -250,7 +259,7 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
             }  
         }  
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 2, End: 16},
 			},
 		},
@@ -266,11 +275,59 @@ func TestScanYorTagsRanges_ValidResourceBlock(t *testing.T) {
 			})
 		}
     `,
-			want: []tokensRange{
+			want: []TokensRange{
 				{Start: 4, End: 12},
 				{Start: 14, End: 22},
 			},
 		},
+		{
+			name: "yor key as conditional result is not a yor tags map",
+			code: `
+        resource "example_resource" "example_instance" {
+            tags = {
+                name = var.x ? "yor_trace" : var.y
+            }
+        }
+    `,
+			want: []TokensRange{},
+		},
+		{
+			name: "yor key in for expression is not a yor tags map",
+			code: `
+        resource "example_resource" "example_instance" {
+            tags = { for k, v in var.tags : k => k == "x" ? "yor_trace" : v }
+        }
+    `,
+			want: []TokensRange{},
+		},
+		{
+			name: "yor tags map nested in other object",
+			code: `
+        resource "example_resource" "example_instance" {
+            tags = {
+                name = "example"
+                yor = { yor_trace = "example_trace" }
+            }
+        }
+    `,
+			want: []TokensRange{
+				{Start: 12, End: 18},
+			},
+		},
+		{
+			name: "object nested in yor tags map is boxed as a whole",
+			code: `
+        resource "example_resource" "example_instance" {
+            tags = {
+                yor_trace = "example_trace"
+                nested = { git_commit = "12345" }
+            }
+        }
+    `,
+			want: []TokensRange{
+				{Start: 2, End: 20},
+			},
+		},
 	}
 	for i := 0; i < len(inputs); i++ {
 		input := inputs[i]
@@ -380,7 +437,7 @@ func TestRemoveYorToggles(t *testing.T) {
 			tokens := file.Body().Blocks()[0].Body().GetAttribute("tags").BuildTokens(hclwrite.Tokens{})
 			require.NotNil(t, tokens)
 
-			tokensWithoutToggle := removeYorToggles(tokens)
+			tokensWithoutToggle := removeYorToggles(tokens, DefaultBoxMarkers)
 
 			assert.Equal(t, formatHcl(t, input.want), formatHcl(t, string(tokensWithoutToggle.Bytes())))
 		})
@@ -642,6 +699,48 @@ func TestChangingBoxTemplate(t *testing.T) {
 	assert.Equal(t, formatHcl(t, expected), formatHcl(t, boxedCode))
 }
 
+func TestCustomBoxMarkers(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = merge((/*<box>*/(var.other_toggle ? /*</box>*/{
+    env = "dev"
+  }/*<box>*/ : {})/*</box>*/), {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  })
+}
+`
+	expected := `resource "example_resource" "example_instance" {
+  tags = merge((/*<box>*/(var.other_toggle ? /*</box>*/{
+    env = "dev"
+  }/*<box>*/ : {})/*</box>*/), (/*<yorbox:trace>*/ (var.yor_toggle ? /*</yorbox:trace>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<yorbox:trace>*/ : {}) /*</yorbox:trace>*/))
+}
+`
+	// the default template denotes the box with the configured markers too
+	for _, boxTemplate := range []string{`{{ .boxOpenMarker }} (var.{{ .toggleName }} ? {{ .boxCloseMarker }} { yor_trace = 123 } {{ .boxOpenMarker }} : {}) {{ .boxCloseMarker }}`, ""} {
+		options := NewOptions("", "yor_toggle", boxTemplate, "", nil)
+		options.BoxMarkers = BoxMarkers{Open: "/*<yorbox:trace>*/", Close: "/*</yorbox:trace>*/"}
+		require.NoError(t, options.ValidateLayers())
+		file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+		require.False(t, diags.HasErrors())
+		BoxFile(file, options)
+		assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+
+		file, diags = hclwrite.ParseConfig(file.Bytes(), "", hcl.InitialPos)
+		require.False(t, diags.HasErrors())
+		BoxFile(file, options)
+		assert.Equal(t, formatHcl(t, expected), formatHcl(t, string(file.Bytes())))
+	}
+}
+
+func TestBoxMarkersValidate(t *testing.T) {
+	assert.NoError(t, DefaultBoxMarkers.Validate())
+	assert.NoError(t, BoxMarkers{Open: "/*<yorbox:trace>*/", Close: "/*</yorbox:trace>*/"}.Validate())
+	assert.Error(t, BoxMarkers{Open: "# box", Close: "/*</box>*/"}.Validate())
+	assert.Error(t, BoxMarkers{Open: "/*<box>*/", Close: "/*<box>*/"}.Validate())
+	assert.Error(t, BoxMarkers{Open: "/*<box*/>*/", Close: "/*</box>*/"}.Validate())
+}
+
 func TestIgnoreResourceType(t *testing.T) {
 	hclCode := `  
 	resource "modtm_telemetry" "telemetry" {  
@@ -678,3 +777,491 @@ func getTagsTokens(block *hclwrite.Block) hclwrite.Tokens {
 	}
 	return tags.BuildTokens(hclwrite.Tokens{})
 }
+
+func TestProcessStream(t *testing.T) {
+	in := strings.NewReader(`resource "example_resource" "example_instance" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`)
+	out := &bytes.Buffer{}
+	err := ProcessStream(in, out, "main.tf", NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	expected := `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`
+	assert.Equal(t, expected, out.String())
+
+	err = ProcessStream(strings.NewReader(`resource "example_resource" {`), out, "main.tf", NewOptions("", "yor_toggle", "", "", nil))
+	assert.ErrorContains(t, err, "main.tf")
+}
+
+func TestProcessFiles(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	expected := `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`
+	dir := t.TempDir()
+	subDir := filepath.Join(dir, "sub")
+	require.NoError(t, os.Mkdir(subDir, 0700))
+	files := []string{filepath.Join(dir, "main.tf"), filepath.Join(dir, "untouched.tf"), filepath.Join(subDir, "sub.tf")}
+	for _, f := range files {
+		require.NoError(t, os.WriteFile(f, []byte(code), 0600))
+	}
+
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	results, err := ProcessFiles([]string{files[0], subDir}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, files[0], results[0].Path)
+	assert.True(t, results[0].Modified)
+	assert.Equal(t, files[2], results[1].Path)
+	assert.True(t, results[1].Modified)
+	assert.Equal(t, expected, readTestFile(t, files[0]))
+	assert.Equal(t, code, readTestFile(t, files[1]))
+	assert.Equal(t, expected, readTestFile(t, files[2]))
+
+	results, err = ProcessFiles([]string{files[0], subDir}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.False(t, results[0].Modified)
+	assert.False(t, results[1].Modified)
+}
+
+func TestProcessFiles_InvalidPaths(t *testing.T) {
+	dir, path := writeTestFile(t, "")
+	readme := filepath.Join(dir, "readme.md")
+	require.NoError(t, os.WriteFile(readme, []byte("# readme"), 0600))
+	options := NewOptions("", "yor_toggle", "", "", nil)
+
+	_, err := ProcessFiles([]string{path, readme}, options)
+	assert.ErrorContains(t, err, "readme.md is not a Terraform HCL file")
+	_, err = ProcessFiles([]string{filepath.Join(dir, "missing.tf")}, options)
+	assert.Error(t, err)
+}
+
+func TestBoxFileLogsDecisions(t *testing.T) {
+	code := `resource "modtm_telemetry" "ignored" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+
+resource "example_resource" "no_tags" {
+}
+
+resource "example_resource" "no_yor_tags" {
+  tags = {
+    env = "dev"
+  }
+}
+
+resource "example_resource" "boxed" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+
+data "example_data" "this" {
+}
+`
+	buff := &bytes.Buffer{}
+	options := NewOptions("", "yor_toggle", "", "", []string{"modtm_telemetry"})
+	options.Logger = slog.New(slog.NewJSONHandler(buff, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	BoxFile(file, options)
+
+	var entries []map[string]any
+	decoder := json.NewDecoder(buff)
+	for decoder.More() {
+		entry := make(map[string]any)
+		require.NoError(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, fmt.Sprintf("%v %v", entry["block"], entry["msg"]))
+	}
+	assert.Equal(t, []string{
+		"modtm_telemetry.ignored skipping ignored tags attribute",
+		"example_resource.no_tags skipping block without tags attribute",
+		"example_resource.no_yor_tags no tags map with yor_trace, yor_name or git_commit key found",
+		"example_resource.no_yor_tags processed tags attribute",
+		"example_resource.boxed processed tags attribute",
+		"<nil> skipping block that is neither a resource nor a module",
+	}, messages)
+	assert.Equal(t, "ignored-by-type", entries[0]["action"])
+	assert.Equal(t, "unchanged", entries[3]["action"])
+	assert.Equal(t, "boxed", entries[4]["action"])
+}
+
+func TestProcessFilesInParallel(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("main%02d.tf", i))
+		require.NoError(t, os.WriteFile(path, []byte(code), 0600))
+		files = append(files, path)
+	}
+
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Parallelism = 4
+	results, err := ProcessFiles([]string{dir}, options)
+	require.NoError(t, err)
+	require.Len(t, results, len(files))
+	for i, r := range results {
+		assert.Equal(t, files[i], r.Path)
+		assert.True(t, r.Modified)
+		assert.Equal(t, "example_resource.example_instance", r.Blocks[0].Address)
+	}
+
+	for _, path := range files {
+		require.NoError(t, os.WriteFile(path, []byte(code), 0600))
+	}
+	require.NoError(t, os.WriteFile(files[5], []byte(`resource "example_resource" {`), 0600))
+	results, err = ProcessFiles([]string{dir}, options)
+	assert.ErrorContains(t, err, "main05.tf")
+	require.Len(t, results, 5)
+	for i, r := range results {
+		assert.Equal(t, files[i], r.Path)
+	}
+	// files after the failed one are left as they were
+	for i, path := range files {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		if i < 5 {
+			assert.NotEqual(t, code, string(content))
+		} else if i > 5 {
+			assert.Equal(t, code, string(content))
+		}
+	}
+}
+
+func TestNewBoxer_InvalidTemplate(t *testing.T) {
+	_, err := NewBoxer(NewOptions("", "yor_toggle", "{ yor_trace = 123 }", "", nil))
+	assert.Error(t, err)
+}
+
+func TestBoxerReusedAcrossFiles(t *testing.T) {
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	code := `resource "example_resource" "example" {
+  tags = {
+    yor_trace = "123"
+  }
+}
+`
+	first, _, err := boxer.boxBytes([]byte(code), "a.tf")
+	require.NoError(t, err)
+	second, _, err := boxer.boxBytes([]byte(code), "b.tf")
+	require.NoError(t, err)
+	assert.Equal(t, string(first), string(second))
+	assert.NotEqual(t, code, string(first))
+}
+
+func benchmarkCode(resources int) []byte {
+	sb := strings.Builder{}
+	for i := 0; i < resources; i++ {
+		sb.WriteString(fmt.Sprintf(`resource "example_resource" "example%d" {
+  name = "example%d"
+  tags = {
+    yor_trace = "%d"
+  }
+}
+
+`, i, i, i))
+	}
+	return []byte(sb.String())
+}
+
+func BenchmarkBoxFile_RenderTemplatePerBlock(b *testing.B) {
+	code := benchmarkCode(200)
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, _ := hclwrite.ParseConfig(code, "main.tf", hcl.InitialPos)
+		for _, block := range file.Body().Blocks() {
+			boxTagsTokensForBlock(block, options)
+		}
+	}
+}
+
+func BenchmarkBoxFile_PreparedBoxer(b *testing.B) {
+	code := benchmarkCode(200)
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, _ := hclwrite.ParseConfig(code, "main.tf", hcl.InitialPos)
+		boxer.BoxFile(file)
+	}
+}
+
+func TestSpliceBoxes(t *testing.T) {
+	tokens := hclwrite.Tokens{
+		{Type: hclsyntax.TokenIdent, Bytes: []byte("merge")},
+		{Type: hclsyntax.TokenOParen, Bytes: []byte("(")},
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte("{")},
+		{Type: hclsyntax.TokenCBrace, Bytes: []byte("}")},
+		{Type: hclsyntax.TokenComma, Bytes: []byte(",")},
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte("{")},
+		{Type: hclsyntax.TokenCBrace, Bytes: []byte("}")},
+		{Type: hclsyntax.TokenCParen, Bytes: []byte(")")},
+	}
+	box := Box{
+		Left:  hclwrite.Tokens{{Type: hclsyntax.TokenOParen, Bytes: []byte("(")}},
+		Right: hclwrite.Tokens{{Type: hclsyntax.TokenCParen, Bytes: []byte(")")}},
+	}
+	spliced := spliceBoxes(tokens, []TokensRange{{Start: 2, End: 3}, {Start: 5, End: 6}}, func(TokensRange) Box {
+		return box
+	})
+	assert.Equal(t, "merge(({}),({}))", strings.ReplaceAll(string(spliced.Bytes()), " ", ""))
+	assert.NotSame(t, box.Left[0], spliced[2])
+
+	spliced = spliceBoxes(tokens, []TokensRange{{Start: 1, End: 7}, {Start: 2, End: 3}, {Start: 5, End: 6}}, func(TokensRange) Box {
+		return box
+	})
+	assert.Equal(t, "merge(({},{}))", strings.ReplaceAll(string(spliced.Bytes()), " ", ""))
+}
+
+func largeTagsCode(merges int) []byte {
+	sb := strings.Builder{}
+	sb.WriteString("resource \"example_resource\" \"example\" {\n  tags = merge(\n")
+	for i := 0; i < merges; i++ {
+		sb.WriteString(fmt.Sprintf("    {\n      yor_trace = \"%d\"\n      git_commit = \"%d\"\n    },\n", i, i))
+	}
+	sb.WriteString("  )\n}\n")
+	return []byte(sb.String())
+}
+
+// spliceBoxesWithArrayList reproduces the arraylist based implementation spliceBoxes replaced, each side of a box is
+// inserted at once, it is kept as the baseline of benchmarks.
+func spliceBoxesWithArrayList(tokens hclwrite.Tokens, ranges []TokensRange, box Box) hclwrite.Tokens {
+	output := arraylist.New()
+	for _, token := range tokens {
+		output.Add(token)
+	}
+	interfaces := func(tokens hclwrite.Tokens) []any {
+		result := make([]any, len(tokens))
+		for i, token := range tokens {
+			result[i] = token
+		}
+		return result
+	}
+	for i := len(ranges) - 1; i >= 0; i-- {
+		output.Insert(ranges[i].End+1, interfaces(cloneTokens(box.Right))...)
+		output.Insert(ranges[i].Start, interfaces(cloneTokens(box.Left))...)
+	}
+	result := hclwrite.Tokens{}
+	it := output.Iterator()
+	for it.Next() {
+		result = append(result, it.Value().(*hclwrite.Token))
+	}
+	return result
+}
+
+func benchmarkSplice(b *testing.B, merges int, splice func(hclwrite.Tokens, []TokensRange, Box) hclwrite.Tokens) {
+	file, diag := hclwrite.ParseConfig(largeTagsCode(merges), "main.tf", hcl.InitialPos)
+	require.False(b, diag.HasErrors())
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	tokens := getTagsTokens(file.Body().Blocks()[0])
+	ranges := scanYorTagsRanges(tokens, options)
+	require.Len(b, ranges, merges)
+	box, diag := options.buildLayeredBox()
+	require.False(b, diag.HasErrors())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		splice(tokens, ranges, box)
+	}
+}
+
+func BenchmarkSpliceBoxes(b *testing.B) {
+	for _, merges := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("linear-%d", merges), func(b *testing.B) {
+			benchmarkSplice(b, merges, func(tokens hclwrite.Tokens, ranges []TokensRange, box Box) hclwrite.Tokens {
+				return spliceBoxes(tokens, ranges, func(TokensRange) Box {
+					return box
+				})
+			})
+		})
+		b.Run(fmt.Sprintf("arraylist-%d", merges), func(b *testing.B) {
+			benchmarkSplice(b, merges, spliceBoxesWithArrayList)
+		})
+	}
+}
+
+func BenchmarkBoxFile_LargeTags(b *testing.B) {
+	code := largeTagsCode(1000)
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, _ := hclwrite.ParseConfig(code, "main.tf", hcl.InitialPos)
+		boxer.BoxFile(file)
+	}
+}
+
+func BenchmarkBoxFile_LargeTagsSplitGitTags(b *testing.B) {
+	code := largeTagsCode(1000)
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.SplitGitTags = true
+	options.RedactTags = []string{"git_commit"}
+	boxer, err := NewBoxer(options)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, _ := hclwrite.ParseConfig(code, "main.tf", hcl.InitialPos)
+		boxer.BoxFile(file)
+	}
+}
+
+func TestOmitParens(t *testing.T) {
+	template := `/*<box>*/ var.{{ .toggleName }} ? /*</box>*/ { yor_trace = 123 } /*<box>*/ : {} /*</box>*/`
+	inputs := []struct {
+		name string
+		code string
+		want string
+	}{
+		{
+			name: "unboxed tags",
+			code: `resource "example_resource" "example" {
+  tags = {
+    yor_trace = "123"
+  }
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = /*<box>*/ var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {} /*</box>*/
+}
+`,
+		},
+		{
+			name: "merge call",
+			code: `resource "example_resource" "example" {
+  tags = merge({
+    env = "dev"
+  }, {
+    yor_trace = "123"
+  })
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = merge({
+    env = "dev"
+    }, /*<box>*/ var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {} /*</box>*/)
+}
+`,
+		},
+		{
+			name: "boxed with parens",
+			code: `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = /*<box>*/ var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {} /*</box>*/
+}
+`,
+		},
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input.name, func(t *testing.T) {
+			options := NewOptions("", "yor_toggle", template, "", nil)
+			options.OmitParens = true
+			boxer, err := NewBoxer(options)
+			require.NoError(t, err)
+			boxed, _, err := boxer.boxBytes([]byte(input.code), "main.tf")
+			require.NoError(t, err)
+			assert.Equal(t, formatHcl(t, input.want), formatHcl(t, string(boxed)))
+
+			reboxed, blocks, err := boxer.boxBytes(boxed, "main.tf")
+			require.NoError(t, err)
+			assert.Equal(t, string(boxed), string(reboxed))
+			require.Len(t, blocks, 1)
+			assert.Equal(t, ActionUnchanged, blocks[0].Action)
+		})
+	}
+}
+
+func TestOmitParens_BackToParens(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = /*<box>*/ var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {} /*</box>*/ # keep me
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	boxed, _, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/) # keep me
+}
+`), formatHcl(t, string(boxed)))
+}
+
+func TestBoxBytes(t *testing.T) {
+	code := `resource "example_resource" "boxed" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "example_resource" "example" {
+  tags = {
+    yor_trace = "456"
+  }
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Contains(t, string(boxed), `tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "456"`)
+	assert.Equal(t, []Change{
+		{
+			Address:   "example_resource.example",
+			Attribute: "tags",
+			Action:    ActionBoxed,
+			Range: hcl.Range{
+				Filename: "main.tf",
+				Start:    hcl.Pos{Line: 8, Column: 3, Byte: 188},
+				End:      hcl.Pos{Line: 10, Column: 4, Byte: 222},
+			},
+		},
+	}, changes)
+
+	_, _, diags = boxer.BoxBytes([]byte(`resource "example_resource" "example" {`), "main.tf")
+	assert.True(t, diags.HasErrors())
+}
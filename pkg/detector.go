@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// TagDetector finds ranges of tokens to box in the tokens of a tags expression of a block. Ranges are boxed by the
+// BoxRenderer one by one, ranges that are out of the tokens or overlap others are dropped. Tags are also stripped,
+// redacted, split and recognised structurally in the ranges that are maps.
+type TagDetector interface {
+	Detect(block *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange
+}
+
+// AttributeSelector could be implemented by a TagDetector to box attributes other than the `tags` attribute of
+// resource and module blocks. Attributes returns names of attributes of the block to box, in a stable order.
+type AttributeSelector interface {
+	Attributes(block *hclwrite.Block) []string
+}
+
+// BoxRenderer produces the box that wraps a range of tokens found by TagDetector. The returned tokens are cloned
+// before they're inserted, so the same box could be returned for every range. Boxes must be denoted by the box markers
+// of Options, so they could be removed when tags are boxed again.
+type BoxRenderer interface {
+	Render(block *hclwrite.Block, tokens hclwrite.Tokens, r TokensRange) Box
+}
+
+// NewYorTagDetector returns the default TagDetector, which finds the outermost object constructors that contain keys
+// that only exist in tags generated by yor, with Options.TagsPrefix.
+func NewYorTagDetector(options Options) TagDetector {
+	return yorTagDetector{options: options}
+}
+
+type yorTagDetector struct {
+	options Options
+}
+
+func (d yorTagDetector) Detect(_ *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange {
+	return scanYorTagsRanges(tokens, d.options)
+}
+
+// NewTemplateBoxRenderer returns the default BoxRenderer, which renders boxes from the box templates of all layers of
+// Options. Templates are rendered only once. Maps that only contain git metadata tags are boxed by the git box
+// template if Options.SplitGitTags is set.
+func NewTemplateBoxRenderer(options Options) (BoxRenderer, error) {
+	renderer, err := newTemplateBoxRenderer(options)
+	if err != nil {
+		return nil, err
+	}
+	return renderer, nil
+}
+
+func newTemplateBoxRenderer(options Options) (templateBoxRenderer, error) {
+	box, diag := options.buildLayeredBox()
+	if diag.HasErrors() {
+		return templateBoxRenderer{}, diag
+	}
+	renderer := templateBoxRenderer{
+		options: options,
+		box:     box,
+	}
+	if options.SplitGitTags {
+		if renderer.gitBox, diag = options.buildLayerBox(options.gitLayer()); diag.HasErrors() {
+			return templateBoxRenderer{}, diag
+		}
+	}
+	return renderer, nil
+}
+
+type templateBoxRenderer struct {
+	options Options
+	box     Box
+	gitBox  Box
+}
+
+func (r templateBoxRenderer) Render(_ *hclwrite.Block, tokens hclwrite.Tokens, tokensRange TokensRange) Box {
+	if r.options.SplitGitTags && isGitTagsMap(tokens, tokensRange, r.options) {
+		return r.gitBox
+	}
+	return r.box
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ownerTagDetector boxes every object that has an `owner` key.
+type ownerTagDetector struct{}
+
+func (ownerTagDetector) Detect(_ *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange {
+	var ranges []TokensRange
+	start := -1
+	for i, token := range tokens {
+		switch {
+		case token.Type == hclsyntax.TokenOBrace:
+			start = i
+		case token.Type == hclsyntax.TokenIdent && string(token.Bytes) == "owner" && start >= 0:
+			for j := i; j < len(tokens); j++ {
+				if tokens[j].Type == hclsyntax.TokenCBrace {
+					ranges = append(ranges, TokensRange{Start: start, End: j})
+					break
+				}
+			}
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// resourceTypeBoxRenderer boxes tags with a toggle named after the resource type.
+type resourceTypeBoxRenderer struct {
+	t *testing.T
+}
+
+func (r resourceTypeBoxRenderer) Render(block *hclwrite.Block, _ hclwrite.Tokens, _ TokensRange) Box {
+	box, diag := BuildBoxFromTemplate("/*<box>*/ (var." + block.Labels()[0] + "_tags ? /*</box>*/ { owner = 123 } /*<box>*/ : {}) /*</box>*/")
+	require.False(r.t, diag.HasErrors())
+	return box
+}
+
+func TestCustomTagDetectorAndBoxRenderer(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = merge({
+    owner = "team"
+  }, {
+    yor_trace = "123"
+  })
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.TagDetector = ownerTagDetector{}
+	options.BoxRenderer = resourceTypeBoxRenderer{t: t}
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	require.Len(t, changes, 1)
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = merge((/*<box>*/ (var.example_resource_tags ? /*</box>*/ {
+    owner = "team"
+  } /*<box>*/ : {}) /*</box>*/), {
+    yor_trace = "123"
+  })
+}
+`), formatHcl(t, string(boxed)))
+}
+
+func TestDefaultTagDetectorAndBoxRenderer(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = {
+    yor_trace = "123"
+  }
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	renderer, err := NewTemplateBoxRenderer(options)
+	require.NoError(t, err)
+	explicit := options
+	explicit.TagDetector = NewYorTagDetector(options)
+	explicit.BoxRenderer = renderer
+
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	want, _, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	boxer, err = NewBoxer(explicit)
+	require.NoError(t, err)
+	actual, _, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, string(want), string(actual))
+
+	_, err = NewTemplateBoxRenderer(NewOptions("", "yor_toggle", "{ yor_trace = 123 }", "", nil))
+	assert.Error(t, err)
+}
+
+// overlappingTagDetector returns ranges out of the tokens, and ranges overlapping each other.
+type overlappingTagDetector struct{}
+
+func (overlappingTagDetector) Detect(_ *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange {
+	ranges := []TokensRange{{Start: -1, End: 2}, {Start: 0, End: len(tokens)}, {Start: 3, End: 1}}
+	for i, token := range tokens {
+		if token.Type == hclsyntax.TokenOBrace {
+			ranges = append(ranges, TokensRange{Start: i, End: len(tokens) - 1}, TokensRange{Start: i, End: i + 1})
+		}
+	}
+	return ranges
+}
+
+func TestTagDetectorRangesAreNormalized(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = {
+    owner = "team"
+  }
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.TagDetector = overlappingTagDetector{}
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	require.Len(t, changes, 1)
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    owner = "team"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`), formatHcl(t, string(boxed)))
+}
+
+func TestCustomTagDetectorWithStripTagsAndStructural(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = merge(var.tags, var.yor_toggle ? {
+    owner  = "team"
+    secret = "s3cr3t"
+  } : {})
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.TagDetector = ownerTagDetector{}
+	options.StripTags = []string{"secret"}
+	options.Structural = true
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, _, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    owner = "team"
+  } /*<box>*/ : {}) /*</box>*/))
+}
+`), formatHcl(t, string(boxed)))
+}
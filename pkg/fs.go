@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// FileWriter writes files that have been changed by boxing, names are the same as those used to read the files from
+// Options.FS.
+type FileWriter interface {
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// osFS is the file system of the OS. Unlike os.DirFS, names are OS paths, which could be absolute or relative to the
+// working directory.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (o Options) fileSystem() fs.FS {
+	if o.FS == nil {
+		return osFS{}
+	}
+	return o.FS
+}
+
+func (o Options) fileWriter() FileWriter {
+	if o.Writer != nil {
+		return o.Writer
+	}
+	if w, ok := o.fileSystem().(FileWriter); ok {
+		return w
+	}
+	return readOnlyFS{}
+}
+
+type readOnlyFS struct{}
+
+func (readOnlyFS) WriteFile(name string, _ []byte, _ fs.FileMode) error {
+	return fmt.Errorf("cannot write %s: Options.FS is read-only and Options.Writer is not set", name)
+}
+
+// joinPath joins a directory and a file name found in it, with the separator used by the file system.
+func joinPath(fsys fs.FS, dir, name string) string {
+	if _, ok := fsys.(osFS); ok {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(dir, name)
+}
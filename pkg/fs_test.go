@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const unboxedCode = `resource "example_resource" "example" {
+  tags = {
+    yor_trace = "123"
+  }
+}
+`
+
+type memoryWriter struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	perms map[string]fs.FileMode
+}
+
+func (w *memoryWriter) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.files == nil {
+		w.files = make(map[string][]byte)
+		w.perms = make(map[string]fs.FileMode)
+	}
+	w.files[name] = data
+	w.perms[name] = perm
+	return nil
+}
+
+func TestProcessDirectory_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"modules/network/main.tf": {Data: []byte(unboxedCode), Mode: 0640},
+		"modules/network/boxed.tf": {Data: []byte(`resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`)},
+		"modules/network/readme.md": {Data: []byte("# readme")},
+		"main.tf":                   {Data: []byte(unboxedCode)},
+	}
+	writer := &memoryWriter{}
+	options := NewOptions("modules/network", "yor_toggle", "", "", nil)
+	options.FS = fsys
+	options.Writer = writer
+
+	require.NoError(t, ProcessDirectory(options))
+	require.Len(t, writer.files, 1)
+	assert.Equal(t, fs.FileMode(0640), writer.perms["modules/network/main.tf"])
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`), formatHcl(t, string(writer.files["modules/network/main.tf"])))
+	assert.Equal(t, unboxedCode, string(fsys["modules/network/main.tf"].Data))
+}
+
+func TestProcessFiles_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.tf":   {Data: []byte(unboxedCode)},
+		"b.tf":   {Data: []byte(unboxedCode)},
+		"c.json": {Data: []byte("{}")},
+	}
+	writer := &memoryWriter{}
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.FS = fsys
+	options.Writer = writer
+
+	results, err := ProcessFiles([]string{"b.tf"}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Modified)
+	assert.Contains(t, writer.files, "b.tf")
+	assert.NotContains(t, writer.files, "a.tf")
+
+	_, err = ProcessFiles([]string{"c.json"}, options)
+	assert.Error(t, err)
+	_, err = ProcessFiles([]string{"missing.tf"}, options)
+	assert.Error(t, err)
+}
+
+func TestProcessDirectory_ReadOnlyFS(t *testing.T) {
+	options := NewOptions(".", "yor_toggle", "", "", nil)
+	options.FS = fstest.MapFS{
+		"main.tf": {Data: []byte(unboxedCode)},
+	}
+	assert.ErrorContains(t, ProcessDirectory(options), "read-only")
+
+	options.Check = true
+	results, err := ProcessFiles([]string{"."}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Modified)
+}
+
+// cancelingFS cancels the context after reading the given number of files.
+type cancelingFS struct {
+	fstest.MapFS
+	cancel context.CancelFunc
+	after  int
+	reads  int
+}
+
+func (f *cancelingFS) ReadFile(name string) ([]byte, error) {
+	f.reads++
+	if f.reads == f.after {
+		f.cancel()
+	}
+	return f.MapFS.ReadFile(name)
+}
+
+func TestProcessFilesContext_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fsys := &cancelingFS{
+		MapFS: fstest.MapFS{
+			"a.tf": {Data: []byte(unboxedCode)},
+			"b.tf": {Data: []byte(unboxedCode)},
+			"c.tf": {Data: []byte(unboxedCode)},
+			"d.tf": {Data: []byte(unboxedCode)},
+		},
+		cancel: cancel,
+		after:  2,
+	}
+	writer := &memoryWriter{}
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.FS = fsys
+	options.Writer = writer
+	options.Parallelism = 1
+
+	results, err := ProcessFilesContext(ctx, []string{"."}, options)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	var canceled *CanceledError
+	require.True(t, errors.As(err, &canceled))
+	// b.tf has been read, but it's canceled before its first block is boxed
+	assert.Equal(t, []string{"b.tf", "c.tf", "d.tf"}, canceled.Unprocessed)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a.tf", results[0].Path)
+	assert.Len(t, writer.files, 1)
+	assert.Contains(t, writer.files, "a.tf")
+}
+
+func TestProcessDirectoryContext_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	writer := &memoryWriter{}
+	options := NewOptions(".", "yor_toggle", "", "", nil)
+	options.FS = fstest.MapFS{
+		"main.tf": {Data: []byte(unboxedCode)},
+	}
+	options.Writer = writer
+
+	err := ProcessDirectoryContext(ctx, options)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.ErrorContains(t, err, "1 files not processed: main.tf")
+	assert.Empty(t, writer.files)
+}
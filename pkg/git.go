@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChangedFiles lists .tf files under dir that have been added, modified, renamed or copied relative to ref, untracked
+// files are included. The working tree is compared with the index when ref is empty. It relies on the local git
+// binary only, no network access is required.
+func ChangedFiles(dir, ref string) ([]string, error) {
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid git ref %q", ref)
+	}
+	// renamed files are listed as added ones with --no-renames, otherwise they're filtered out by --diff-filter
+	diffArgs := []string{"diff", "--name-only", "-z", "--relative", "--no-renames", "--diff-filter=AM"}
+	if ref != "" {
+		diffArgs = append(diffArgs, ref)
+	}
+	changed, err := git(dir, append(diffArgs, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(dir, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	var files []string
+	for _, name := range append(changed, untracked...) {
+		if filepath.Ext(name) != ".tf" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		files = append(files, filepath.Join(dir, filepath.FromSlash(name)))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// git runs the git command in dir and splits its NUL separated output.
+func git(dir string, args ...string) ([]string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...) // #nosec G204
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	var result []string
+	for _, name := range strings.Split(string(output), "\x00") {
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
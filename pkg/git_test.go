@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	run("init", "-q")
+	write("main.tf", "locals {}\n")
+	write("unchanged.tf", "locals {\n  a = 1\n  b = 2\n  c = 3\n  d = 4\n  e = 5\n}\n")
+	write("readme.md", "# readme\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "init")
+
+	write("main.tf", "locals {\n  a = 1\n}\n")
+	write("readme.md", "# changed\n")
+	write("modules/new.tf", "locals {}\n")
+
+	files, err := ChangedFiles(dir, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "main.tf"), filepath.Join(dir, "modules", "new.tf")}, files)
+
+	run("add", "main.tf")
+	files, err = ChangedFiles(dir, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "modules", "new.tf")}, files)
+
+	files, err = ChangedFiles(filepath.Join(dir, "modules"), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "modules", "new.tf")}, files)
+
+	run("mv", "unchanged.tf", "renamed.tf")
+	// a small change keeps the file similar enough to be detected as a rename by git
+	write("renamed.tf", "locals {\n  a = 1\n  b = 2\n  c = 3\n  d = 4\n  e = 6\n}\n")
+	run("add", "renamed.tf")
+	files, err = ChangedFiles(dir, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "main.tf"), filepath.Join(dir, "modules", "new.tf"), filepath.Join(dir, "renamed.tf")}, files)
+
+	_, err = ChangedFiles(dir, "--output=/tmp/x")
+	assert.Error(t, err)
+	_, err = ChangedFiles(dir, "no-such-ref")
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// BoxMigration describes a box that was found in a file before migrating it to the current template.
+type BoxMigration struct {
+	File  string
+	Block string
+	Layer string
+	Left  string
+	Right string
+	// Known is true when the box has been rendered by the current template or one of Options.KnownTemplates.
+	Known bool
+}
+
+func (m BoxMigration) String() string {
+	state := "known"
+	if !m.Known {
+		state = "unknown"
+	}
+	return fmt.Sprintf("%s: %s [%s] left=%q right=%q (%s)", m.File, m.Block, m.Layer, m.Left, m.Right, state)
+}
+
+// MigrateDirectory reports all existing boxes in the directory and rewrites them with the current templates.
+// Nothing would be written if there is any box that doesn't match a known template, unless Options.Force is set.
+func MigrateDirectory(options Options) ([]BoxMigration, error) {
+	files, err := terraformFiles(options.fileSystem(), options.Path)
+	if err != nil {
+		return nil, err
+	}
+	knownBoxes := options.knownBoxes()
+	var migrations []BoxMigration
+	unknown := 0
+	for _, filePath := range files {
+		data, err := fs.ReadFile(options.fileSystem(), filePath)
+		if err != nil {
+			return nil, err
+		}
+		f, diag := hclwrite.ParseConfig(data, filepath.Base(filePath), hcl.InitialPos)
+		if diag.HasErrors() {
+			return nil, diag
+		}
+		for _, block := range f.Body().Blocks() {
+			tags, action := tagsAttribute(block, options)
+			if tags == nil || action != "" {
+				continue
+			}
+			tokens := expressionTokens(tags)
+			for _, layer := range options.BoxLayers() {
+				for _, box := range findBoxes(tokens, layer.BoxMarkers) {
+					m := BoxMigration{
+						File:  filePath,
+						Block: blockAddress(block),
+						Layer: layer.Name,
+						Left:  box.Left,
+						Right: box.Right,
+						Known: knownBoxes[layer.Name][box],
+					}
+					if !m.Known {
+						unknown++
+					}
+					migrations = append(migrations, m)
+				}
+			}
+		}
+	}
+	if unknown > 0 && !options.Force {
+		return migrations, fmt.Errorf("%d box(es) don't match any known template, nothing has been migrated", unknown)
+	}
+	return migrations, ProcessDirectory(options)
+}
+
+// boxText is the text inside the markers on both sides of a box, with insignificant whitespaces removed.
+type boxText struct {
+	Left  string
+	Right string
+}
+
+// knownBoxes renders the current template and all known templates for every layer, with the current toggle name and
+// all known toggle names, templates that don't contain the layer's markers are skipped. Boxes of the git layer are known to layers sharing its markers if
+// Options.SplitGitTags is set, since they're found along with boxes of these layers.
+func (o Options) knownBoxes() map[string]map[boxText]bool {
+	result := make(map[string]map[boxText]bool)
+	for _, layer := range o.BoxLayers() {
+		result[layer.Name] = make(map[boxText]bool)
+		for _, tpl := range append([]string{layer.BoxTemplate}, o.KnownTemplates...) {
+			for _, toggleName := range append([]string{o.ToggleName}, o.KnownToggleNames...) {
+				l := layer
+				l.BoxTemplate = tpl
+				opts := o
+				opts.ToggleName = toggleName
+				box, diag := opts.buildLayerBox(l)
+				if diag.HasErrors() {
+					continue
+				}
+				for _, b := range findBoxes(append(box.Left, box.Right...), layer.BoxMarkers) {
+					result[layer.Name][b] = true
+				}
+			}
+		}
+	}
+	if !o.SplitGitTags {
+		return result
+	}
+	gitLayer := o.gitLayer()
+	gitBox, diag := o.buildLayerBox(gitLayer)
+	if diag.HasErrors() {
+		return result
+	}
+	for _, layer := range o.BoxLayers() {
+		if layer.BoxMarkers != gitLayer.BoxMarkers {
+			continue
+		}
+		for _, b := range findBoxes(append(gitBox.Left, gitBox.Right...), gitLayer.BoxMarkers) {
+			result[layer.Name][b] = true
+		}
+	}
+	return result
+}
+
+// findBoxes collects boxes denoted by the markers, the first marked region is the left side of a box, the
+// second one is the right side, and so on.
+func findBoxes(tokens hclwrite.Tokens, markers BoxMarkers) []boxText {
+	var regions []string
+	var region hclwrite.Tokens
+	inBox := false
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment && string(token.Bytes) == markers.Open {
+			inBox = true
+			region = hclwrite.Tokens{}
+			continue
+		}
+		if token.Type == hclsyntax.TokenComment && string(token.Bytes) == markers.Close {
+			if inBox {
+				regions = append(regions, normalizedText(region))
+			}
+			inBox = false
+			continue
+		}
+		if inBox {
+			region = append(region, token)
+		}
+	}
+	var boxes []boxText
+	for i := 0; i+1 < len(regions); i += 2 {
+		boxes = append(boxes, boxText{Left: regions[i], Right: regions[i+1]})
+	}
+	return boxes
+}
+
+// normalizedText joins the tokens with single spaces so that formatting doesn't affect comparison.
+func normalizedText(tokens hclwrite.Tokens) string {
+	var parts []string
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenNewline {
+			continue
+		}
+		parts = append(parts, strings.TrimSpace(string(token.Bytes)))
+	}
+	return strings.Join(parts, " ")
+}
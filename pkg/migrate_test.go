@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oldTemplate = `/*<box>*/ (var.{{ .toggleName }} ? /*</box>*/ { yor_trace = 123 } /*<box>*/ : {}) /*</box>*/`
+const newTemplate = `/*<box>*/ (var.{{ .toggleName }} ? { for k, v in /*</box>*/ { yor_trace = 123 } /*<box>*/ : "my_prefix_${k}" => v } : {}) /*</box>*/`
+
+const boxedByOldTemplate = `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/(var.yor_toggle ? /*</box>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<box>*/ : {})/*</box>*/)
+}
+`
+
+const boxedByNewTemplate = `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.yor_toggle ? { for k, v in /*</box>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<box>*/ : "my_prefix_${k}" => v } : {}) /*</box>*/)
+}
+`
+
+func writeTestFile(t *testing.T, content string) (string, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return dir, path
+}
+
+func readTestFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestMigrateDirectory_KnownTemplate(t *testing.T) {
+	dir, path := writeTestFile(t, boxedByOldTemplate)
+	options := NewOptions(dir, "yor_toggle", newTemplate, "", nil)
+	options.KnownTemplates = []string{oldTemplate}
+
+	migrations, err := MigrateDirectory(options)
+	require.NoError(t, err)
+	assert.Equal(t, []BoxMigration{
+		{
+			File:  path,
+			Block: "example_resource.example_instance",
+			Layer: "default",
+			Left:  "( var . yor_toggle ?",
+			Right: ": { } )",
+			Known: true,
+		},
+	}, migrations)
+	assert.Equal(t, formatHcl(t, boxedByNewTemplate), formatHcl(t, readTestFile(t, path)))
+
+	migrations, err = MigrateDirectory(options)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	assert.Equal(t, "( var . yor_toggle ? { for k , v in", migrations[0].Left)
+	assert.True(t, migrations[0].Known)
+}
+
+func TestMigrateDirectory_UnknownTemplate(t *testing.T) {
+	dir, path := writeTestFile(t, boxedByOldTemplate)
+	options := NewOptions(dir, "yor_toggle", newTemplate, "", nil)
+
+	migrations, err := MigrateDirectory(options)
+	require.Error(t, err)
+	require.Len(t, migrations, 1)
+	assert.False(t, migrations[0].Known)
+	assert.Equal(t, boxedByOldTemplate, readTestFile(t, path))
+
+	options.Force = true
+	_, err = MigrateDirectory(options)
+	require.NoError(t, err)
+	assert.Equal(t, formatHcl(t, boxedByNewTemplate), formatHcl(t, readTestFile(t, path)))
+}
+
+func TestMigrateDirectory_SplitGitTags(t *testing.T) {
+	dir, path := writeTestFile(t, `resource "example_resource" "example_instance" {
+  tags = {
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    yor_trace  = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`)
+	options := NewOptions(dir, "yor_toggle", "", "", nil)
+	options.SplitGitTags = true
+	require.NoError(t, ProcessDirectory(options))
+	boxed := readTestFile(t, path)
+
+	migrations, err := MigrateDirectory(options)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	for _, m := range migrations {
+		assert.True(t, m.Known, m.String())
+	}
+	assert.Equal(t, formatHcl(t, boxed), formatHcl(t, readTestFile(t, path)))
+}
+
+func TestMigrateDirectory_KnownToggleName(t *testing.T) {
+	dir, path := writeTestFile(t, boxedByOldTemplate)
+	options := NewOptions(dir, "new_toggle", "", "", nil)
+	options.KnownTemplates = []string{oldTemplate}
+
+	migrations, err := MigrateDirectory(options)
+	require.Error(t, err)
+	require.Len(t, migrations, 1)
+	assert.False(t, migrations[0].Known)
+
+	options.KnownToggleNames = []string{"yor_toggle"}
+	migrations, err = MigrateDirectory(options)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	assert.True(t, migrations[0].Known)
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.new_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`), formatHcl(t, readTestFile(t, path)))
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// markerCorruption locates the marker that breaks the balance of box markers in a tags attribute.
+type markerCorruption struct {
+	Marker string
+	// Occurrence is the index of the marker among all occurrences of the same marker in the attribute.
+	Occurrence int
+	Detail     string
+}
+
+// boxMarkers returns markers of all layers, including the git layer, without duplicates.
+func (o Options) boxMarkers() []BoxMarkers {
+	layers := o.BoxLayers()
+	if o.SplitGitTags {
+		layers = append(layers, o.gitLayer())
+	}
+	var result []BoxMarkers
+	seen := make(map[BoxMarkers]bool)
+	for _, layer := range layers {
+		if !seen[layer.BoxMarkers] {
+			seen[layer.BoxMarkers] = true
+			result = append(result, layer.BoxMarkers)
+		}
+	}
+	return result
+}
+
+// checkBoxMarkers returns nil if every open marker in tokens is followed by a close marker before the next open
+// marker, for all markers.
+func checkBoxMarkers(tokens hclwrite.Tokens, markers []BoxMarkers) *markerCorruption {
+	for _, m := range markers {
+		occurrences := make(map[string]int)
+		inBox := false
+		lastOpen := 0
+		for _, token := range tokens {
+			if token.Type != hclsyntax.TokenComment {
+				continue
+			}
+			text := string(token.Bytes)
+			if text != m.Open && text != m.Close {
+				continue
+			}
+			occurrence := occurrences[text]
+			occurrences[text]++
+			if text == m.Open && inBox {
+				return &markerCorruption{Marker: text, Occurrence: occurrence, Detail: fmt.Sprintf("%s is opened again before the previous %s is closed by %s", m.Open, m.Open, m.Close)}
+			}
+			if text == m.Close && !inBox {
+				return &markerCorruption{Marker: text, Occurrence: occurrence, Detail: fmt.Sprintf("%s has no matching %s", m.Close, m.Open)}
+			}
+			inBox = text == m.Open
+			if inBox {
+				lastOpen = occurrence
+			}
+		}
+		if inBox {
+			return &markerCorruption{Marker: m.Open, Occurrence: lastOpen, Detail: fmt.Sprintf("%s is not closed by %s", m.Open, m.Close)}
+		}
+	}
+	return nil
+}
+
+// repair strips all box markers from tokens of an attribute of the block, then removes boxes recognised structurally. It returns false if the
+// result isn't a valid expression, or no box around tags found by the TagDetector could be recognised in it, e.g. the box was
+// rendered with another toggle, since what's left of the box would become code that could never be removed again.
+func (b *Boxer) repair(block *hclwrite.Block, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	markers := make(map[string]bool)
+	for _, m := range b.options.boxMarkers() {
+		markers[m.Open] = true
+		markers[m.Close] = true
+	}
+	stripped := hclwrite.Tokens{}
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment && markers[string(token.Bytes)] {
+			continue
+		}
+		stripped = append(stripped, token)
+	}
+	if _, diag := hclsyntax.ParseExpression(stripped.Bytes(), "", hcl.InitialPos); diag.HasErrors() {
+		return nil, false
+	}
+	repaired, removed := b.removeStructuralBoxes(block, stripped)
+	if !removed {
+		return nil, false
+	}
+	return repaired, true
+}
+
+// corruptionDiagnostic points to the marker that corrupts the tags attribute of the block in the source.
+func corruptionDiagnostic(src []byte, filename string, block BlockResult) *hcl.Diagnostic {
+	diag := &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Unbalanced box markers",
+		Detail:   fmt.Sprintf("%s of %s is left as is: %s, fix it by hand or run with repair mode", block.Attribute, block.Address, block.corruption.Detail),
+		Subject:  block.Range.Ptr(),
+	}
+	r := block.Range
+	if r.Empty() || r.End.Byte > len(src) {
+		return diag
+	}
+	// markers trailing the expression are out of the attribute's range, but on the same line
+	end := r.End.Byte
+	for end < len(src) && src[end] != '\n' {
+		end++
+	}
+	tokens, _ := hclsyntax.LexConfig(src[r.Start.Byte:end], filename, r.Start)
+	occurrence := 0
+	for _, token := range tokens {
+		if token.Type != hclsyntax.TokenComment || string(token.Bytes) != block.corruption.Marker {
+			continue
+		}
+		if occurrence == block.corruption.Occurrence {
+			diag.Subject = token.Range.Ptr()
+			break
+		}
+		occurrence++
+	}
+	return diag
+}
@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckBoxMarkers(t *testing.T) {
+	inputs := []struct {
+		name string
+		code string
+		want *markerCorruption
+	}{
+		{
+			name: "balanced",
+			code: `tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ { yor_trace = "123" } /*<box>*/ : {}) /*</box>*/)`,
+		},
+		{
+			name: "no markers",
+			code: `tags = { yor_trace = "123" }`,
+		},
+		{
+			name: "missing close marker",
+			code: `tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ { yor_trace = "123" } /*<box>*/ : {}))`,
+			want: &markerCorruption{Marker: "/*<box>*/", Occurrence: 1, Detail: "/*<box>*/ is not closed by /*</box>*/"},
+		},
+		{
+			name: "missing open marker",
+			code: `tags = ((var.yor_toggle ? /*</box>*/ { yor_trace = "123" } /*<box>*/ : {}) /*</box>*/)`,
+			want: &markerCorruption{Marker: "/*</box>*/", Occurrence: 0, Detail: "/*</box>*/ has no matching /*<box>*/"},
+		},
+		{
+			name: "open marker opened again",
+			code: `tags = (/*<box>*/ (var.yor_toggle ? { yor_trace = "123" } /*<box>*/ : {}) /*</box>*/)`,
+			want: &markerCorruption{Marker: "/*<box>*/", Occurrence: 1, Detail: "/*<box>*/ is opened again before the previous /*<box>*/ is closed by /*</box>*/"},
+		},
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input.name, func(t *testing.T) {
+			f, diag := hclwrite.ParseConfig([]byte(input.code), "main.tf", hcl.InitialPos)
+			require.False(t, diag.HasErrors())
+			tokens := expressionTokens(f.Body().GetAttribute("tags"))
+			assert.Equal(t, input.want, checkBoxMarkers(tokens, NewOptions("", "yor_toggle", "", "", nil).boxMarkers()))
+		})
+	}
+}
+
+func TestCorruptedBoxIsLeftAsIs(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}))
+}
+
+resource "example_resource" "other" {
+  tags = {
+    yor_trace = "456"
+  }
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	boxed, blocks, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+	// the corrupted attribute is left as is, other attributes are still boxed
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}))
+}
+
+resource "example_resource" "other" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "456"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`), formatHcl(t, string(boxed)))
+	require.Len(t, blocks, 2)
+	assert.Equal(t, ActionCorrupted, blocks[0].Action)
+	assert.Equal(t, ActionBoxed, blocks[1].Action)
+
+	diags := FileResult{Blocks: blocks}.Diagnostics()
+	require.Len(t, diags, 1)
+	assert.Equal(t, "Unbalanced box markers", diags[0].Summary)
+	require.NotNil(t, diags[0].Subject)
+	assert.Equal(t, "main.tf", diags[0].Subject.Filename)
+	assert.Equal(t, 4, diags[0].Subject.Start.Line)
+	assert.Equal(t, 5, diags[0].Subject.Start.Column)
+}
+
+func TestRepairCorruptedBox(t *testing.T) {
+	want := `resource "example_resource" "example" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/))
+}
+`
+	inputs := []struct {
+		name string
+		code string
+	}{
+		{
+			name: "missing close marker",
+			code: `resource "example_resource" "example" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {})))
+}
+`,
+		},
+		{
+			name: "missing open marker",
+			code: `resource "example_resource" "example" {
+  tags = merge(var.tags, ((var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/))
+}
+`,
+		},
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input.name, func(t *testing.T) {
+			options := NewOptions("", "yor_toggle", "", "", nil)
+			options.Repair = true
+			boxer, err := NewBoxer(options)
+			require.NoError(t, err)
+			boxed, blocks, err := boxer.boxBytes([]byte(input.code), "main.tf")
+			require.NoError(t, err)
+			assert.Equal(t, formatHcl(t, want), formatHcl(t, string(boxed)))
+			require.Len(t, blocks, 1)
+			assert.Equal(t, ActionRepaired, blocks[0].Action)
+		})
+	}
+}
+
+func TestRepairWithoutYorTagsMap(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ var.tags /*<box>*/ : {}))
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.Repair = true
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, blocks, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, code, string(boxed))
+	require.Len(t, blocks, 1)
+	assert.Equal(t, ActionCorrupted, blocks[0].Action)
+	assert.Len(t, FileResult{Blocks: blocks}.Diagnostics(), 1)
+}
+
+func TestRepairBoxOfAnotherToggle(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.old_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}))
+}
+`
+	options := NewOptions("", "new_toggle", "", "", nil)
+	options.Repair = true
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, blocks, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, code, string(boxed))
+	require.Len(t, blocks, 1)
+	assert.Equal(t, ActionCorrupted, blocks[0].Action)
+	assert.Len(t, FileResult{Blocks: blocks}.Diagnostics(), 1)
+}
+
+func TestCorruptedBoxDoesNotStopOtherFiles(t *testing.T) {
+	writer := &memoryWriter{}
+	options := NewOptions(".", "yor_toggle", "", "", nil)
+	options.FS = fstest.MapFS{
+		"a.tf": {Data: []byte(`resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}))
+}
+`)},
+		"b.tf": {Data: []byte(unboxedCode)},
+	}
+	options.Writer = writer
+	options.Parallelism = 1
+
+	results, err := ProcessFiles([]string{"."}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Len(t, results[0].Diagnostics(), 1)
+	assert.False(t, results[0].Modified)
+	assert.True(t, results[1].Modified)
+	assert.Contains(t, writer.files, "b.tf")
+	assert.NotContains(t, writer.files, "a.tf")
+
+	err = ProcessDirectory(options)
+	var diags hcl.Diagnostics
+	require.True(t, errors.As(err, &diags))
+	assert.Equal(t, "Unbalanced box markers", diags[0].Summary)
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Action is what yorbox did to a tags attribute.
+type Action string
+
+const (
+	ActionBoxed               Action = "boxed"
+	ActionReBoxed             Action = "re-boxed"
+	ActionUnchanged           Action = "unchanged"
+	ActionIgnoredByType       Action = "ignored-by-type"
+	ActionIgnoredByAnnotation Action = "ignored-by-annotation"
+	ActionCorrupted           Action = "corrupted"
+	ActionRepaired            Action = "repaired"
+	ActionUnboxed             Action = "unboxed"
+)
+
+// changed returns true if the tags attribute has been rewritten.
+func (a Action) changed() bool {
+	return a == ActionBoxed || a == ActionReBoxed || a == ActionRepaired || a == ActionUnboxed
+}
+
+// ignoreAnnotation in a comment of the tags attribute, e.g. `# yorbox:ignore`, tells yorbox to leave it as is.
+const ignoreAnnotation = "yorbox:ignore"
+
+// BlockResult describes how the tags attribute of a resource or module block has been processed. Ranges refer
+// to the source before boxing.
+type BlockResult struct {
+	Address   string      `json:"address"`
+	Attribute string      `json:"attribute"`
+	YorKeys   []string    `json:"yorKeys"`
+	Action    Action      `json:"action"`
+	Range     hcl.Range   `json:"range"`
+	TagRanges []hcl.Range `json:"tagRanges"`
+
+	blockIndex int
+	corruption *markerCorruption
+	// err is why the attribute has been left as is, e.g. a tag couldn't be redacted.
+	err error
+	// diagnostic points to the source of corruption or err.
+	diagnostic *hcl.Diagnostic
+}
+
+// Report is the machine-readable summary of a run.
+type Report struct {
+	Files []FileResult `json:"files"`
+}
+
+func WriteJSONReport(w io.Writer, results []FileResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Report{Files: results})
+}
+
+func boxingAction(original, boxed hclwrite.Tokens, option Options) Action {
+	if sameTokens(original, boxed) {
+		return ActionUnchanged
+	}
+	layers := option.BoxLayers()
+	if option.SplitGitTags {
+		layers = append(layers, option.gitLayer())
+	}
+	for _, layer := range layers {
+		if containsComment(original, layer.Open) {
+			return ActionReBoxed
+		}
+	}
+	return ActionBoxed
+}
+
+// sameTokens compares tokens ignoring spaces between them.
+func sameTokens(a, b hclwrite.Tokens) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || string(a[i].Bytes) != string(b[i].Bytes) {
+			return false
+		}
+	}
+	return true
+}
+
+// fillSourceRanges locates tags attributes and yor tags maps of the block results in the source.
+func fillSourceRanges(blocks []BlockResult, src []byte, filename string, option Options) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	f, diag := hclsyntax.ParseConfig(src, filename, hcl.InitialPos)
+	if diag.HasErrors() {
+		return diag
+	}
+	body := f.Body.(*hclsyntax.Body)
+	for i := range blocks {
+		attr, ok := body.Blocks[blocks[i].blockIndex].Body.Attributes[blocks[i].Attribute]
+		if !ok {
+			continue
+		}
+		blocks[i].Range = attr.SrcRange
+		blocks[i].TagRanges = yorObjectRanges(attr.Expr, option)
+	}
+	return nil
+}
+
+// yorObjectRanges returns ranges of all object constructors in the expression that contain yor's keys.
+func yorObjectRanges(expr hclsyntax.Expression, option Options) []hcl.Range {
+	var ranges []hcl.Range
+	_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+		if obj, ok := node.(*hclsyntax.ObjectConsExpr); ok && isYorObject(obj, option) {
+			ranges = append(ranges, obj.SrcRange)
+		}
+		return nil
+	})
+	return ranges
+}
+
+func isYorObject(obj *hclsyntax.ObjectConsExpr, option Options) bool {
+	for _, item := range obj.Items {
+		if key, ok := objectConsKey(item.KeyExpr); ok && option.isYorKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// objectConsKey returns the key of an object constructor item if it's a literal, like `yor_trace` or `"yor_trace"`.
+func objectConsKey(expr hclsyntax.Expression) (string, bool) {
+	key, diags := expr.Value(nil)
+	if diags.HasErrors() || !key.IsKnown() || key.IsNull() || !key.Type().Equals(cty.String) {
+		return "", false
+	}
+	return key.AsString(), true
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoxBytesBlockResults(t *testing.T) {
+	code := `resource "new_resource" "this" {
+  tags = merge(var.tags, {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  })
+}
+
+resource "boxed_resource" "this" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "outdated_resource" "this" {
+  tags = (/*<box>*/ (var.another_toggle ? /*</box>*/ {
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "modtm_telemetry" "this" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+
+module "annotated" {
+  # yorbox:ignore
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+
+module "no_tags" {
+}
+
+data "example_data" "this" {
+  tags = {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", []string{"modtm_telemetry"}))
+	require.NoError(t, err)
+	_, blocks, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+
+	var actions []Action
+	for _, b := range blocks {
+		actions = append(actions, b.Action)
+	}
+	assert.Equal(t, []Action{ActionBoxed, ActionUnchanged, ActionReBoxed, ActionIgnoredByType, ActionIgnoredByAnnotation}, actions)
+	assert.Equal(t, "new_resource.this", blocks[0].Address)
+	assert.Equal(t, "tags", blocks[0].Attribute)
+	assert.Equal(t, []string{"yor_trace"}, blocks[0].YorKeys)
+	assert.Equal(t, []string{"git_commit"}, blocks[2].YorKeys)
+	assert.Equal(t, "module.annotated", blocks[4].Address)
+	assert.Equal(t, hcl.Range{
+		Filename: "main.tf",
+		Start:    hcl.Pos{Line: 2, Column: 3, Byte: 35},
+		End:      hcl.Pos{Line: 4, Column: 5, Byte: 119},
+	}, blocks[0].Range)
+	assert.Equal(t, []hcl.Range{{
+		Filename: "main.tf",
+		Start:    hcl.Pos{Line: 2, Column: 26, Byte: 58},
+		End:      hcl.Pos{Line: 4, Column: 4, Byte: 118},
+	}}, blocks[0].TagRanges)
+}
+
+func TestBoxBytesKeepsUnchangedContent(t *testing.T) {
+	code := `resource "example_resource"   "this" {
+  tags =    {
+    env = "dev"
+  }
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	boxed, _, err := boxer.boxBytes([]byte(code), "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, code, string(boxed))
+}
+
+func TestWriteJSONReport(t *testing.T) {
+	buff := &bytes.Buffer{}
+	err := WriteJSONReport(buff, []FileResult{
+		{
+			Path:     "main.tf",
+			Modified: true,
+			Blocks: []BlockResult{
+				{
+					Address:   "example_resource.this",
+					Attribute: "tags",
+					YorKeys:   []string{"yor_trace"},
+					Action:    ActionBoxed,
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	var report map[string]any
+	require.NoError(t, json.Unmarshal(buff.Bytes(), &report))
+	file := report["files"].([]any)[0].(map[string]any)
+	assert.Equal(t, "main.tf", file["path"])
+	assert.Equal(t, true, file["modified"])
+	block := file["blocks"].([]any)[0].(map[string]any)
+	assert.Equal(t, "example_resource.this", block["address"])
+	assert.Equal(t, "boxed", block["action"])
+	assert.Equal(t, []any{"yor_trace"}, block["yorKeys"])
+}
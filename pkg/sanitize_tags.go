@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+const defaultRedactTemplate = `"redacted"`
+
+// sanitizeTags removes tags listed in Options.StripTags from the maps in ranges, and replaces values of tags listed in
+// Options.RedactTags with the rendered Options.RedactTemplate. Ranges must be sorted and must not overlap, ranges that
+// are not maps are skipped. Tokens are rewritten in a single pass, like spliceBoxes. An error is returned if any value can't be redacted,
+// since the value to hide would be left as is.
+func sanitizeTags(tokens hclwrite.Tokens, ranges []TokensRange, option Options) (hclwrite.Tokens, error) {
+	if len(option.StripTags) == 0 && len(option.RedactTags) == 0 {
+		return tokens, nil
+	}
+	strip := option.prefixedTags(option.StripTags)
+	redact := option.prefixedTags(option.RedactTags)
+	result := make(hclwrite.Tokens, 0, len(tokens))
+	next := 0
+	for _, r := range ranges {
+		if tokens[r.Start].Type != hclsyntax.TokenOBrace {
+			continue
+		}
+		changed := false
+		var items []objectItem
+		for _, item := range objectItems(tokens, r) {
+			if strip[item.Key] {
+				changed = true
+				continue
+			}
+			if redact[item.Key] {
+				redacted, err := redactItem(item, option)
+				if err != nil {
+					return nil, fmt.Errorf("cannot redact tag %s: %w", item.Key, err)
+				}
+				// values redacted by a previous run are left as is
+				if !sameTokens(item.Tokens, redacted.Tokens) {
+					item = redacted
+					changed = true
+				}
+			}
+			items = append(items, item)
+		}
+		if !changed {
+			continue
+		}
+		result = append(result, tokens[next:r.Start]...)
+		result = append(result, objectTokens(items)...)
+		next = r.End + 1
+	}
+	return append(result, tokens[next:]...), nil
+}
+
+func (o Options) prefixedTags(keys []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, key := range keys {
+		result[o.TagsPrefix+key] = true
+	}
+	return result
+}
+
+// ValidateRedactTemplate ensures the redact template could be rendered into a valid HCL expression for every tag to
+// redact.
+func (o Options) ValidateRedactTemplate() error {
+	for key := range o.prefixedTags(o.RedactTags) {
+		if _, err := o.renderRedactedValue(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactItem replaces the value of the item with the rendered redact template, lead comments and the line
+// comment of the item are kept. The template only sees the key, so the original value never leaks into the result,
+// and a redacted value is rendered the same when tags are boxed again.
+func redactItem(item objectItem, option Options) (objectItem, error) {
+	valueStart := -1
+	valueEnd := len(item.Tokens)
+	for i, token := range item.Tokens {
+		if valueStart < 0 && (token.Type == hclsyntax.TokenEqual || token.Type == hclsyntax.TokenColon) {
+			valueStart = i + 1
+		}
+	}
+	if valueStart < 0 {
+		return item, fmt.Errorf("cannot find value of tag %s", item.Key)
+	}
+	if last := item.Tokens[len(item.Tokens)-1]; endsWithNewline(last) {
+		valueEnd--
+	}
+	value, err := option.renderRedactedValue(item.Key)
+	if err != nil {
+		return item, err
+	}
+	tokens := append(hclwrite.Tokens{}, item.Tokens[:valueStart]...)
+	tokens = append(tokens, value...)
+	tokens = append(tokens, item.Tokens[valueEnd:]...)
+	return objectItem{Key: item.Key, Tokens: tokens}, nil
+}
+
+// renderRedactedValue renders the redact template for the key, referring to any variable that doesn't exist, like the
+// original value, is an error.
+func (o Options) renderRedactedValue(key string) (hclwrite.Tokens, error) {
+	rendered, err := o.executeTemplate(template.New("Redact").Option("missingkey=error"), o.RedactTemplate, map[string]any{
+		"key": key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, diag := hclwrite.ParseConfig([]byte(fmt.Sprintf("value = %s", rendered)), "", hcl.InitialPos)
+	if diag.HasErrors() {
+		return nil, diag
+	}
+	attr := f.Body().GetAttribute("value")
+	if attr == nil {
+		return nil, fmt.Errorf("redact template must render a single expression, got %s", rendered)
+	}
+	tokens := attr.Expr().BuildTokens(nil)
+	if len(tokens) > 0 {
+		tokens[0].SpacesBefore = 1
+	}
+	return tokens, nil
+}
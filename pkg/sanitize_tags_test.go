@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeTags(t *testing.T) {
+	inputs := []struct {
+		name           string
+		code           string
+		expected       string
+		stripTags      []string
+		redactTags     []string
+		redactTemplate string
+		tagsPrefix     string
+	}{
+		{
+			name: "strip tags",
+			code: `resource "example_resource" "example_instance" {
+  tags = {
+    git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    git_last_modified_by = "hezijie@microsoft.com"
+    git_modifiers        = "hezijie/lonegunmanb"
+    yor_trace            = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    yor_trace  = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<box>*/ : {}) /*</box>*/)
+}
+`,
+			stripTags: []string{"git_last_modified_by", "git_modifiers"},
+		},
+		{
+			name: "redact tags with default template",
+			code: `resource "example_resource" "example_instance" {
+  tags = merge(var.tags, (/*<box>*/(var.yor_toggle ? /*</box>*/{
+    git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    git_last_modified_by = "hezijie@microsoft.com" # committer
+  }/*<box>*/ : {})/*</box>*/))
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    git_last_modified_by = "redacted" # committer
+  }/*<box>*/ : {}) /*</box>*/))
+}
+`,
+			redactTags: []string{"git_last_modified_by"},
+		},
+		{
+			name: "redact tags with template and prefix",
+			code: `resource "example_resource" "example_instance" {
+  tags = {
+    my_git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    my_git_last_modified_by = "hezijie@microsoft.com"
+  }
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    my_git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    my_git_last_modified_by = "${var.owner}-my_git_last_modified_by"
+  }/*<box>*/ : {}) /*</box>*/)
+}
+`,
+			redactTags:     []string{"git_last_modified_by"},
+			redactTemplate: `"${var.owner}-{{ .key }}"`,
+			tagsPrefix:     "my_",
+		},
+		{
+			name: "tags that are not generated by yor are kept",
+			code: `resource "example_resource" "example_instance" {
+  tags = {
+    git_modifiers = "hezijie/lonegunmanb"
+  }
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = {
+    git_modifiers = "hezijie/lonegunmanb"
+  }
+}
+`,
+			stripTags: []string{"git_modifiers"},
+		},
+	}
+	for i := 0; i < len(inputs); i++ {
+		input := inputs[i]
+		t.Run(input.name, func(t *testing.T) {
+			options := NewOptions("", "yor_toggle", "", input.tagsPrefix, nil)
+			options.StripTags = input.stripTags
+			options.RedactTags = input.redactTags
+			if input.redactTemplate != "" {
+				options.RedactTemplate = input.redactTemplate
+			}
+			require.NoError(t, options.ValidateRedactTemplate())
+
+			file, diags := hclwrite.ParseConfig([]byte(input.code), "", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			BoxFile(file, options)
+			assert.Equal(t, formatHcl(t, input.expected), formatHcl(t, string(file.Bytes())))
+
+			file, diags = hclwrite.ParseConfig(file.Bytes(), "", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			BoxFile(file, options)
+			assert.Equal(t, formatHcl(t, input.expected), formatHcl(t, string(file.Bytes())))
+		})
+	}
+}
+
+func TestValidateRedactTemplate(t *testing.T) {
+	options := NewOptions("", "", "", "", nil)
+	options.RedactTags = []string{"git_modifiers"}
+	assert.NoError(t, options.ValidateRedactTemplate())
+
+	options.RedactTemplate = `"{{ .key }}`
+	assert.Error(t, options.ValidateRedactTemplate())
+}
+
+func TestRedactTemplateFails(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = {
+    git_last_modified_by = "a@b.com"
+    yor_trace            = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.RedactTags = []string{"git_last_modified_by"}
+	// the original value is not available to the template, it must never be copied into the result
+	options.RedactTemplate = `sha1({{ .value }})`
+	require.Error(t, options.ValidateRedactTemplate())
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.True(t, diags.HasErrors())
+	assert.Equal(t, "Failed to sanitize tags", diags[0].Summary)
+	require.NotNil(t, diags[0].Subject)
+	assert.Equal(t, 2, diags[0].Subject.Start.Line)
+	assert.Empty(t, changes)
+	assert.Equal(t, code, string(boxed))
+	assert.NotContains(t, diags.Error(), "a@b.com")
+}
+
+func TestRedactTagsIsIdempotent(t *testing.T) {
+	code := `resource "example_resource" "example_instance" {
+  tags = {
+    git_last_modified_by = "alice"
+    yor_trace            = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.RedactTags = []string{"git_last_modified_by"}
+	options.RedactTemplate = `sha1("{{ .key }}")`
+	require.NoError(t, options.ValidateRedactTemplate())
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	require.Len(t, changes, 1)
+	assert.Contains(t, string(boxed), `sha1("git_last_modified_by")`)
+	assert.NotContains(t, string(boxed), "alice")
+	for i := 0; i < 2; i++ {
+		reboxed, changes, diags := boxer.BoxBytes(boxed, "main.tf")
+		require.False(t, diags.HasErrors())
+		assert.Empty(t, changes)
+		assert.Equal(t, string(boxed), string(reboxed))
+	}
+}
@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+const (
+	ruleUnboxedYorTags  = "unboxed-yor-tags"
+	ruleMisboxedYorTags = "misboxed-yor-tags"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+// sarifSrcRoot is the base of artifact URIs relative to the working directory.
+const sarifSrcRoot = "%SRCROOT%"
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// WriteSARIFReport writes a SARIF 2.1.0 log with a result for every yor tags map that is not boxed, or boxed
+// with an outdated box, so code scanning tools could annotate them. Paths of files are written relative to the working
+// directory, so they could be mapped to files in the repository.
+func WriteSARIFReport(w io.Writer, results []FileResult) error {
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return writeSARIFReport(w, results, root)
+}
+
+// WriteArchiveSARIFReport writes a SARIF 2.1.0 log like WriteSARIFReport for results of BoxArchive, paths of files are
+// entry names in the archive and are written as they are, relative to the root of the archive.
+func WriteArchiveSARIFReport(w io.Writer, results []FileResult) error {
+	return writeSARIFReport(w, results, "")
+}
+
+// writeSARIFReport locates files relative to root, an empty root means paths of files are relative already.
+
+func writeSARIFReport(w io.Writer, results []FileResult, root string) error {
+	run := sarifRun{
+		Tool: sarifTool{
+			Driver: sarifDriver{
+				Name:           "yorbox",
+				InformationURI: "https://github.com/lonegunmanb/yorbox",
+				Rules: []sarifRule{
+					{
+						ID:               ruleUnboxedYorTags,
+						ShortDescription: sarifMessage{Text: "Tags generated by yor are not boxed by a toggle"},
+					},
+					{
+						ID:               ruleMisboxedYorTags,
+						ShortDescription: sarifMessage{Text: "Tags generated by yor are boxed by an outdated or corrupted box"},
+					},
+				},
+			},
+		},
+		Results: []sarifResult{},
+	}
+	if root != "" {
+		run.OriginalURIBaseIDs = map[string]sarifArtifactLocation{
+			sarifSrcRoot: {URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(root) + "/"}).String()},
+		}
+	}
+	for _, file := range results {
+		for _, block := range file.Blocks {
+			var ruleID, message string
+			switch block.Action {
+			case ActionBoxed:
+				ruleID = ruleUnboxedYorTags
+				message = fmt.Sprintf("%s of %s contains tags generated by yor that are not boxed", block.Attribute, block.Address)
+			case ActionReBoxed, ActionRepaired:
+				ruleID = ruleMisboxedYorTags
+				message = fmt.Sprintf("%s of %s contains a box that doesn't match the current box template", block.Attribute, block.Address)
+			case ActionCorrupted:
+				ruleID = ruleMisboxedYorTags
+				message = fmt.Sprintf("%s of %s contains a box with unbalanced box markers", block.Attribute, block.Address)
+			default:
+				continue
+			}
+			ranges := block.TagRanges
+			if len(ranges) == 0 {
+				ranges = []hcl.Range{block.Range}
+			}
+			for _, r := range ranges {
+				run.Results = append(run.Results, sarifResult{
+					RuleID:  ruleID,
+					Level:   "warning",
+					Message: sarifMessage{Text: message},
+					Locations: []sarifLocation{
+						{
+							PhysicalLocation: sarifPhysicalLocation{
+								ArtifactLocation: sarifArtifact(file.Path, root),
+								Region: sarifRegion{
+									StartLine:   r.Start.Line,
+									StartColumn: r.Start.Column,
+									EndLine:     r.End.Line,
+									EndColumn:   r.End.Column,
+								},
+							},
+						},
+					},
+				})
+			}
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// sarifArtifact returns the location of the file relative to root, files out of root are located by absolute paths.
+// Paths are taken as relative already when root is empty.
+func sarifArtifact(path, root string) sarifArtifactLocation {
+	if root == "" {
+		return sarifArtifactLocation{URI: filepath.ToSlash(path), URIBaseID: sarifSrcRoot}
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return sarifArtifactLocation{URI: filepath.ToSlash(path)}
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return sarifArtifactLocation{URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()}
+	}
+	return sarifArtifactLocation{URI: filepath.ToSlash(rel), URIBaseID: sarifSrcRoot}
+}
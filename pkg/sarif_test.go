@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteSARIFReport(t *testing.T) {
+	code := `resource "unboxed_resource" "this" {
+  tags = merge({
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+    }, {
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+  })
+}
+
+resource "misboxed_resource" "this" {
+  tags = (/*<box>*/ (var.another_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "boxed_resource" "this" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`
+	dir, path := writeTestFile(t, code)
+	options := NewOptions(dir, "yor_toggle", "", "", nil)
+	options.Check = true
+	results, err := ProcessFiles([]string{dir}, options)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Modified)
+	assert.Equal(t, code, readTestFile(t, path))
+
+	buff := &bytes.Buffer{}
+	require.NoError(t, writeSARIFReport(buff, results, dir))
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buff.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	assert.Equal(t, "yorbox", log.Runs[0].Tool.Driver.Name)
+	assert.Equal(t, "file://"+filepath.ToSlash(dir)+"/", log.Runs[0].OriginalURIBaseIDs[sarifSrcRoot].URI)
+
+	var rules []string
+	var regions []sarifRegion
+	for _, r := range log.Runs[0].Results {
+		rules = append(rules, r.RuleID)
+		require.Len(t, r.Locations, 1)
+		assert.Equal(t, sarifArtifactLocation{URI: "main.tf", URIBaseID: sarifSrcRoot}, r.Locations[0].PhysicalLocation.ArtifactLocation)
+		regions = append(regions, r.Locations[0].PhysicalLocation.Region)
+	}
+	assert.Equal(t, []string{ruleUnboxedYorTags, ruleUnboxedYorTags, ruleMisboxedYorTags}, rules)
+	assert.Equal(t, []sarifRegion{
+		{StartLine: 2, StartColumn: 16, EndLine: 4, EndColumn: 6},
+		{StartLine: 4, StartColumn: 8, EndLine: 6, EndColumn: 4},
+		{StartLine: 10, StartColumn: 54, EndLine: 12, EndColumn: 4},
+	}, regions)
+}
+
+func TestWriteSARIFReport_NoFindings(t *testing.T) {
+	buff := &bytes.Buffer{}
+	require.NoError(t, WriteSARIFReport(buff, []FileResult{{Path: "main.tf"}}))
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buff.Bytes(), &log))
+	assert.NotNil(t, log.Runs[0].Results)
+	assert.Empty(t, log.Runs[0].Results)
+}
+
+func TestSarifArtifact(t *testing.T) {
+	root := t.TempDir()
+	assert.Equal(t, sarifArtifactLocation{URI: "modules/vpc/main.tf", URIBaseID: sarifSrcRoot}, sarifArtifact(filepath.Join(root, "modules", "vpc", "main.tf"), root))
+	outside := filepath.Join(filepath.Dir(root), "other", "main.tf")
+	assert.Equal(t, sarifArtifactLocation{URI: "file://" + filepath.ToSlash(outside)}, sarifArtifact(outside, root))
+}
+
+func TestWriteSARIFReport_Corrupted(t *testing.T) {
+	dir, path := writeTestFile(t, `resource "corrupted_resource" "this" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  } /*<box>*/ : {}))
+}
+`)
+	options := NewOptions(dir, "yor_toggle", "", "", nil)
+	options.Check = true
+	results, err := ProcessFiles([]string{path}, options)
+	require.NoError(t, err)
+
+	buff := &bytes.Buffer{}
+	require.NoError(t, writeSARIFReport(buff, results, dir))
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buff.Bytes(), &log))
+	require.Len(t, log.Runs[0].Results, 1)
+	assert.Equal(t, ruleMisboxedYorTags, log.Runs[0].Results[0].RuleID)
+	assert.Contains(t, log.Runs[0].Results[0].Message.Text, "unbalanced box markers")
+}
+
+func TestWriteArchiveSARIFReport(t *testing.T) {
+	results := []FileResult{
+		{
+			Path: "modules/vpc/main.tf",
+			Blocks: []BlockResult{
+				{Address: "resource.example_resource.this", Attribute: "tags", Action: ActionBoxed},
+			},
+		},
+	}
+	buff := &bytes.Buffer{}
+	require.NoError(t, WriteArchiveSARIFReport(buff, results))
+	var log sarifLog
+	require.NoError(t, json.Unmarshal(buff.Bytes(), &log))
+	assert.Empty(t, log.Runs[0].OriginalURIBaseIDs)
+	require.Len(t, log.Runs[0].Results, 1)
+	assert.Equal(t, sarifArtifactLocation{URI: "modules/vpc/main.tf", URIBaseID: sarifSrcRoot}, log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation)
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// DefaultGitBoxTemplate boxes git metadata tags with the configured markers when Options.SplitGitTags is set.
+const DefaultGitBoxTemplate = `{{ .boxOpenMarker }} (var.{{ .gitToggleName }} ? {{ .boxCloseMarker }} { git_commit = 123 } {{ .boxOpenMarker }} : {}) {{ .boxCloseMarker }}`
+
+// objectItem is an item of an object constructor, including its lead comments.
+type objectItem struct {
+	Key    string
+	Tokens hclwrite.Tokens
+}
+
+// gitLayer is the box layer that wraps git metadata tags when Options.SplitGitTags is set.
+func (o Options) gitLayer() BoxLayer {
+	return BoxLayer{
+		Name:        "git",
+		BoxTemplate: o.GitBoxTemplate,
+		BoxMarkers:  o.BoxMarkers,
+	}
+}
+
+func (o Options) isGitTag(key string) bool {
+	return strings.HasPrefix(key, o.TagsPrefix+"git_")
+}
+
+// splitGitTags rewrites every map in ranges that mixes git metadata tags with other tags into
+// `merge({other tags}, {git tags})`, so the two maps could be boxed separately. Ranges must be sorted and must not
+// overlap, ranges that are not maps are skipped. Tokens are rewritten in a single pass, like spliceBoxes.
+func splitGitTags(tokens hclwrite.Tokens, ranges []TokensRange, option Options) hclwrite.Tokens {
+	result := make(hclwrite.Tokens, 0, len(tokens))
+	next := 0
+	for _, r := range ranges {
+		if tokens[r.Start].Type != hclsyntax.TokenOBrace {
+			continue
+		}
+		var gitItems, otherItems []objectItem
+		for _, item := range objectItems(tokens, r) {
+			if option.isGitTag(item.Key) {
+				gitItems = append(gitItems, item)
+			} else {
+				otherItems = append(otherItems, item)
+			}
+		}
+		if len(gitItems) == 0 || len(otherItems) == 0 {
+			continue
+		}
+		result = append(result, tokens[next:r.Start]...)
+		result = append(result,
+			&hclwrite.Token{Type: hclsyntax.TokenIdent, Bytes: []byte("merge")},
+			&hclwrite.Token{Type: hclsyntax.TokenOParen, Bytes: []byte("(")},
+		)
+		result = append(result, objectTokens(otherItems)...)
+		result = append(result, &hclwrite.Token{Type: hclsyntax.TokenComma, Bytes: []byte(",")})
+		result = append(result, objectTokens(gitItems)...)
+		result = append(result, &hclwrite.Token{Type: hclsyntax.TokenCParen, Bytes: []byte(")")})
+		next = r.End + 1
+	}
+	return append(result, tokens[next:]...)
+}
+
+// isGitTagsMap returns true if the map in the range contains git metadata tags only.
+func isGitTagsMap(tokens hclwrite.Tokens, r TokensRange, option Options) bool {
+	items := objectItems(tokens, r)
+	for _, item := range items {
+		if !option.isGitTag(item.Key) {
+			return false
+		}
+	}
+	return len(items) > 0
+}
+
+// objectItems splits the object constructor in the range into items, an item ends with a newline or a comma
+// that isn't nested in brackets, parentheses, braces, templates or heredocs.
+func objectItems(tokens hclwrite.Tokens, r TokensRange) []objectItem {
+	var items []objectItem
+	var current hclwrite.Tokens
+	depth := 0
+	flush := func() {
+		if key, ok := itemKey(current); ok {
+			items = append(items, objectItem{Key: key, Tokens: current})
+			current = nil
+		}
+	}
+	for _, token := range tokens[r.Start+1 : r.End] {
+		switch token.Type {
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen, hclsyntax.TokenOQuote,
+			hclsyntax.TokenOHeredoc, hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl:
+			depth++
+		case hclsyntax.TokenCBrace, hclsyntax.TokenCBrack, hclsyntax.TokenCParen, hclsyntax.TokenCQuote,
+			hclsyntax.TokenCHeredoc, hclsyntax.TokenTemplateSeqEnd:
+			depth--
+		case hclsyntax.TokenNewline, hclsyntax.TokenComma:
+			if depth == 0 {
+				flush()
+				continue
+			}
+		case hclsyntax.TokenComment:
+			// a line comment ends with the newline, so it ends the item it trails
+			if depth == 0 && endsWithNewline(token) {
+				current = append(current, token)
+				flush()
+				continue
+			}
+		}
+		current = append(current, token)
+	}
+	flush()
+	return items
+}
+
+// itemKey returns the key of an item, false if the tokens are lead comments only.
+func itemKey(tokens hclwrite.Tokens) (string, bool) {
+	for i, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenComment:
+			continue
+		case hclsyntax.TokenOQuote:
+			if i+1 < len(tokens) && tokens[i+1].Type == hclsyntax.TokenQuotedLit {
+				return string(tokens[i+1].Bytes), true
+			}
+			return "", true
+		case hclsyntax.TokenIdent:
+			return string(token.Bytes), true
+		default:
+			return "", true
+		}
+	}
+	return "", false
+}
+
+func objectTokens(items []objectItem) hclwrite.Tokens {
+	result := hclwrite.Tokens{
+		{Type: hclsyntax.TokenOBrace, Bytes: []byte("{")},
+		{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")},
+	}
+	for _, item := range items {
+		result = append(result, item.Tokens...)
+		if !endsWithNewline(item.Tokens[len(item.Tokens)-1]) {
+			result = append(result, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")})
+		}
+	}
+	return append(result, &hclwrite.Token{Type: hclsyntax.TokenCBrace, Bytes: []byte("}")})
+}
+
+func endsWithNewline(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenComment && strings.HasSuffix(string(token.Bytes), "\n")
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitGitTags(t *testing.T) {
+	inputs := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "mixed yor tags",
+			code: `resource "example_resource" "example_instance" {
+  tags = {
+    # trace
+    yor_trace            = "0c9a0220-f447-473a-a142-0ed147c43691"
+    git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    git_last_modified_by = "hezijie@microsoft.com" # email
+    env                  = "dev"
+  }
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = merge((/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    # trace
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+    env       = "dev"
+    }/*<box>*/ : {}) /*</box>*/), (/*<box>*/ (var.yor_git_toggle ? /*</box>*/{
+    git_commit           = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    git_last_modified_by = "hezijie@microsoft.com" # email
+  }/*<box>*/ : {}) /*</box>*/))
+}
+`,
+		},
+		{
+			name: "mixed yor tags boxed by a single box",
+			code: `resource "example_resource" "example_instance" {
+  tags = merge(var.tags, (/*<box>*/(var.yor_toggle ? /*</box>*/{
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+    yor_trace  = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }/*<box>*/ : {})/*</box>*/))
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = merge(var.tags, merge((/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+    }/*<box>*/ : {}) /*</box>*/), (/*<box>*/ (var.yor_git_toggle ? /*</box>*/{
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+  }/*<box>*/ : {}) /*</box>*/)))
+}
+`,
+		},
+		{
+			name: "separated yor tags",
+			code: `resource "example_resource" "example_instance" {
+  tags = merge({
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+  }, {
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+  })
+}
+`,
+			expected: `resource "example_resource" "example_instance" {
+  tags = merge((/*<box>*/ (var.yor_toggle ? /*</box>*/{
+    yor_trace = "0c9a0220-f447-473a-a142-0ed147c43691"
+    }/*<box>*/ : {}) /*</box>*/), (/*<box>*/ (var.yor_git_toggle ? /*</box>*/{
+    git_commit = "d101883be1a535645f359f1e1a047cf4b30bc2a2"
+  }/*<box>*/ : {}) /*</box>*/))
+}
+`,
+		},
+	}
+	for i := 0; i < len(inputs); i++ {
+		input := inputs[i]
+		t.Run(input.name, func(t *testing.T) {
+			options := NewOptions("", "yor_toggle", "", "", nil)
+			options.SplitGitTags = true
+			require.NoError(t, options.ValidateLayers())
+
+			file, diags := hclwrite.ParseConfig([]byte(input.code), "", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			BoxFile(file, options)
+			assert.Equal(t, formatHcl(t, input.expected), formatHcl(t, string(file.Bytes())))
+
+			file, diags = hclwrite.ParseConfig(file.Bytes(), "", hcl.InitialPos)
+			require.False(t, diags.HasErrors())
+			BoxFile(file, options)
+			assert.Equal(t, formatHcl(t, input.expected), formatHcl(t, string(file.Bytes())))
+		})
+	}
+}
+
+func TestObjectItems(t *testing.T) {
+	code := `tags = {
+  # lead comment
+  yor_trace = "123" # line comment
+  "git_commit": "abc",
+  nested = { a = 1, b = 2 }
+  (var.key) = "${var.a}-${var.b}"
+}
+`
+	file, diags := hclwrite.ParseConfig([]byte(code), "", hcl.InitialPos)
+	require.False(t, diags.HasErrors())
+	tokens := file.Body().GetAttribute("tags").Expr().BuildTokens(nil)
+
+	items := objectItems(tokens, TokensRange{Start: 0, End: len(tokens) - 1})
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	assert.Equal(t, []string{"yor_trace", "git_commit", "nested", ""}, keys)
+	assert.Equal(t, "  # lead comment\n  yor_trace = \"123\" # line comment\n", string(items[0].Tokens.Bytes()))
+}
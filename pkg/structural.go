@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// structuralBox is a box without comments, it's used to recognise boxes whose markers have been stripped.
+type structuralBox struct {
+	Left  hclwrite.Tokens
+	Right hclwrite.Tokens
+}
+
+// structuralBoxes returns boxes that could wrap yor tags maps without markers. Each box is also returned wrapped with
+// one more pair of parens and with its wrapping parens peeled off one pair at a time, so boxes emitted with or without
+// Options.OmitParens are both recognised. Longer boxes come first.
+func (b *Boxer) structuralBoxes() []structuralBox {
+	boxes := []Box{b.box}
+	if b.options.SplitGitTags {
+		boxes = append(boxes, b.gitBox)
+	}
+	var result []structuralBox
+	for _, box := range boxes {
+		left := append(hclwrite.Tokens{{Type: hclsyntax.TokenOParen, Bytes: []byte("(")}}, significantTokens(box.Left)...)
+		right := append(significantTokens(box.Right), &hclwrite.Token{Type: hclsyntax.TokenCParen, Bytes: []byte(")")})
+		for {
+			result = append(result, structuralBox{Left: left, Right: right})
+			if len(left) == 0 || left[0].Type != hclsyntax.TokenOParen || len(right) == 0 || right[len(right)-1].Type != hclsyntax.TokenCParen {
+				break
+			}
+			left = left[1:]
+			right = right[:len(right)-1]
+		}
+	}
+	return result
+}
+
+// removeStructuralBoxes removes boxes around tags found by the TagDetector in tokens of an attribute of the block, boxes
+// are recognised by matching the tokens around the tags against the boxes, comments and newlines are ignored. The
+// matched tokens must be a whole expression, e.g. `var.yor_toggle ? {...} : {}` in `var.force || var.yor_toggle ?
+// {...} : {}` is not a box since the condition is `var.force || var.yor_toggle`. It returns true if any box has been
+// removed.
+func (b *Boxer) removeStructuralBoxes(block *hclwrite.Block, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	boxes := b.structuralBoxes()
+	var expressions map[TokensRange]bool
+	result := hclwrite.Tokens{}
+	next := 0
+	removed := false
+	for _, r := range b.detect(block, tokens) {
+		for _, box := range boxes {
+			start, okLeft := matchBackward(tokens, r.Start-1, box.Left)
+			end, okRight := matchForward(tokens, r.End+1, box.Right)
+			if !okLeft || !okRight || start < next || (tokens[start].Type == hclsyntax.TokenOParen && isCallParen(tokens, start)) {
+				continue
+			}
+			if expressions == nil {
+				expressions = expressionNodeRanges(tokens)
+			}
+			if !expressions[TokensRange{Start: start, End: end}] {
+				continue
+			}
+			result = append(result, tokens[next:start]...)
+			result = append(result, tokens[r.Start:r.End+1]...)
+			next = end + 1
+			removed = true
+			break
+		}
+	}
+	return append(result, tokens[next:]...), removed
+}
+
+// matchBackward matches expected with significant tokens ending at index end, it returns index of the first matched
+// token.
+func matchBackward(tokens hclwrite.Tokens, end int, expected hclwrite.Tokens) (int, bool) {
+	i := end
+	for j := len(expected) - 1; j >= 0; j-- {
+		for i >= 0 && !isSignificant(tokens[i]) {
+			i--
+		}
+		if i < 0 || !sameToken(tokens[i], expected[j]) {
+			return 0, false
+		}
+		i--
+	}
+	return i + 1, true
+}
+
+// matchForward matches expected with significant tokens starting from index start, it returns index of the last
+// matched token.
+func matchForward(tokens hclwrite.Tokens, start int, expected hclwrite.Tokens) (int, bool) {
+	i := start
+	for _, token := range expected {
+		for i < len(tokens) && !isSignificant(tokens[i]) {
+			i++
+		}
+		if i >= len(tokens) || !sameToken(tokens[i], token) {
+			return 0, false
+		}
+		i++
+	}
+	return i - 1, true
+}
+
+func significantTokens(tokens hclwrite.Tokens) hclwrite.Tokens {
+	var result hclwrite.Tokens
+	for _, token := range tokens {
+		if isSignificant(token) {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+func isSignificant(token *hclwrite.Token) bool {
+	return token.Type != hclsyntax.TokenComment && token.Type != hclsyntax.TokenNewline && token.Type != hclsyntax.TokenNil
+}
+
+func sameToken(a, b *hclwrite.Token) bool {
+	return a.Type == b.Type && string(a.Bytes) == string(b.Bytes)
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStructuralBoxing(t *testing.T) {
+	boxed := `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+`
+	inputs := []struct {
+		name       string
+		code       string
+		want       string
+		wantAction Action
+	}{
+		{
+			name: "markers stripped",
+			code: `resource "example_resource" "example" {
+  tags = ((var.yor_toggle ? {
+    yor_trace = "123"
+  } : {}))
+}
+`,
+			want:       boxed,
+			wantAction: ActionReBoxed,
+		},
+		{
+			name: "markers and parens stripped",
+			code: `resource "example_resource" "example" {
+  tags = var.yor_toggle ? {
+    yor_trace = "123"
+  } : {}
+}
+`,
+			want:       boxed,
+			wantAction: ActionReBoxed,
+		},
+		{
+			name: "markers stripped in merge call",
+			code: `resource "example_resource" "example" {
+  tags = merge(var.tags, ((var.yor_toggle ? {
+    yor_trace = "123"
+  } : {})))
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/))
+}
+`,
+			wantAction: ActionReBoxed,
+		},
+		{
+			name:       "boxed with markers",
+			code:       boxed,
+			want:       boxed,
+			wantAction: ActionUnchanged,
+		},
+		{
+			name: "different toggle is not a box",
+			code: `resource "example_resource" "example" {
+  tags = var.other_toggle ? {
+    yor_trace = "123"
+  } : {}
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = var.other_toggle ? (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/) : {}
+}
+`,
+			wantAction: ActionBoxed,
+		},
+		{
+			name: "part of a condition is not a box",
+			code: `resource "example_resource" "example" {
+  tags = var.force || var.yor_toggle ? {
+    yor_trace = "123"
+  } : {}
+}
+`,
+			want: `resource "example_resource" "example" {
+  tags = var.force || var.yor_toggle ? (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/) : {}
+}
+`,
+			wantAction: ActionBoxed,
+		},
+	}
+	for _, input := range inputs {
+		input := input
+		t.Run(input.name, func(t *testing.T) {
+			options := NewOptions("", "yor_toggle", "", "", nil)
+			options.Structural = true
+			boxer, err := NewBoxer(options)
+			require.NoError(t, err)
+			actual, blocks, err := boxer.boxBytes([]byte(input.code), "main.tf")
+			require.NoError(t, err)
+			assert.Equal(t, formatHcl(t, input.want), formatHcl(t, string(actual)))
+			require.Len(t, blocks, 1)
+			assert.Equal(t, input.wantAction, blocks[0].Action)
+		})
+	}
+}
+
+func TestStructuralBoxing_OmitParens(t *testing.T) {
+	options := NewOptions("", "yor_toggle", `/*<box>*/ var.{{ .toggleName }} ? /*</box>*/ { yor_trace = 123 } /*<box>*/ : {} /*</box>*/`, "", nil)
+	options.Structural = true
+	options.OmitParens = true
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	actual, _, err := boxer.boxBytes([]byte(`resource "example_resource" "example" {
+  tags = merge(var.tags, var.yor_toggle ? {
+    yor_trace = "123"
+  } : {})
+}
+`), "main.tf")
+	require.NoError(t, err)
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = merge(var.tags, /*<box>*/ var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {} /*</box>*/)
+}
+`), formatHcl(t, string(actual)))
+}
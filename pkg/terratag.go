@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// terratagLocalPrefix is the prefix of locals added by terratag, like `local.terratag_added_main`.
+const terratagLocalPrefix = "terratag_added_"
+
+// NewTerratagDetector returns a TagDetector for tags generated by terratag, as well as yor. Besides yor tags maps,
+// it finds references to locals added by terratag in `tags` of resource and module blocks, and the values of these
+// locals in `locals` blocks, so they're all boxed by the same box.
+func NewTerratagDetector(options Options) TagDetector {
+	return terratagDetector{options: options}
+}
+
+type terratagDetector struct {
+	options Options
+}
+
+var _ AttributeSelector = terratagDetector{}
+
+func (d terratagDetector) Attributes(block *hclwrite.Block) []string {
+	switch block.Type() {
+	case "resource", "module":
+		return []string{"tags"}
+	case "locals":
+		var names []string
+		for name := range block.Body().Attributes() {
+			if strings.HasPrefix(name, terratagLocalPrefix) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	default:
+		return nil
+	}
+}
+
+func (d terratagDetector) Detect(block *hclwrite.Block, tokens hclwrite.Tokens) []TokensRange {
+	if block.Type() == "locals" {
+		return expressionTokensRanges(tokens, func(expr hclsyntax.Expression) []hcl.Range {
+			return []hcl.Range{expr.Range()}
+		})
+	}
+	return expressionTokensRanges(tokens, func(expr hclsyntax.Expression) []hcl.Range {
+		ranges := yorObjectRanges(expr, d.options)
+		_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hcl.Diagnostics {
+			if traversal, ok := node.(*hclsyntax.ScopeTraversalExpr); ok && isTerratagLocal(traversal.Traversal) {
+				ranges = append(ranges, traversal.SrcRange)
+			}
+			return nil
+		})
+		return ranges
+	})
+}
+
+// isTerratagLocal returns true for references to locals added by terratag, like `local.terratag_added_main`.
+func isTerratagLocal(traversal hcl.Traversal) bool {
+	if len(traversal) < 2 || traversal.RootName() != "local" {
+		return false
+	}
+	attr, ok := traversal[1].(hcl.TraverseAttr)
+	return ok && strings.HasPrefix(attr.Name, terratagLocalPrefix)
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerratagDetector(t *testing.T) {
+	code := `resource "aws_s3_bucket" "merged" {
+  bucket = "merged"
+  tags   = merge(var.tags, local.terratag_added_main)
+}
+
+resource "aws_s3_bucket" "replaced" {
+  bucket = "replaced"
+  tags   = local.terratag_added_main
+}
+
+resource "aws_s3_bucket" "yor" {
+  bucket = "yor"
+  tags = {
+    yor_trace = "123"
+  }
+}
+
+resource "aws_s3_bucket" "other_local" {
+  bucket = "other"
+  tags   = local.tags
+}
+
+locals {
+  terratag_added_main = { "env0_environment_id" = "40907eff", "env0_project_id" = "43fd4ff1" }
+  tags                = { "env" = "dev" }
+}
+`
+	want := `resource "aws_s3_bucket" "merged" {
+  bucket = "merged"
+  tags   = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ local.terratag_added_main /*<box>*/ : {}) /*</box>*/))
+}
+
+resource "aws_s3_bucket" "replaced" {
+  bucket = "replaced"
+  tags   = (/*<box>*/ (var.yor_toggle ? /*</box>*/ local.terratag_added_main /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "aws_s3_bucket" "yor" {
+  bucket = "yor"
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/)
+}
+
+resource "aws_s3_bucket" "other_local" {
+  bucket = "other"
+  tags   = local.tags
+}
+
+locals {
+  terratag_added_main = (/*<box>*/ (var.yor_toggle ? /*</box>*/ { "env0_environment_id" = "40907eff", "env0_project_id" = "43fd4ff1" } /*<box>*/ : {}) /*</box>*/)
+  tags                = { "env" = "dev" }
+}
+`
+	options := NewOptions("", "yor_toggle", "", "", nil)
+	options.TagDetector = NewTerratagDetector(options)
+	boxer, err := NewBoxer(options)
+	require.NoError(t, err)
+	boxed, changes, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, formatHcl(t, want), formatHcl(t, string(boxed)))
+	var changed []string
+	for _, c := range changes {
+		changed = append(changed, c.Address+"."+c.Attribute)
+	}
+	assert.Equal(t, []string{
+		"aws_s3_bucket.merged.tags",
+		"aws_s3_bucket.replaced.tags",
+		"aws_s3_bucket.yor.tags",
+		"locals.terratag_added_main",
+	}, changed)
+
+	reboxed, changes, diags := boxer.BoxBytes(boxed, "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Empty(t, changes)
+	assert.Equal(t, string(boxed), string(reboxed))
+
+	unboxed, _, diags := boxer.UnboxBytes(boxed, "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, formatHcl(t, code), formatHcl(t, string(unboxed)))
+}
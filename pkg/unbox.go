@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"context"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// UnboxBytes removes boxes of all layers from tags in src, leaving the yor tags maps as they were before boxing. The
+// returned bytes are src itself if nothing has been changed. A tags attribute with unbalanced box markers that can't be
+// repaired is left as is and reported by the diagnostics.
+func (b *Boxer) UnboxBytes(src []byte, filename string) ([]byte, []Change, hcl.Diagnostics) {
+	return changeSet(b.rewriteBytes(context.Background(), src, filename, b.unboxAttribute))
+}
+
+func (b *Boxer) unboxAttribute(block *hclwrite.Block, name string) (BlockResult, bool) {
+	logger := b.options.logger().With("block", blockAddress(block), "attribute", name)
+	tags, result, ok := b.attributeToRewrite(block, name, logger)
+	if tags == nil {
+		return result, ok
+	}
+
+	originalTokens := expressionTokens(tags)
+	tokens, _, corruption := b.removeBoxes(block, originalTokens, logger)
+	if corruption != nil {
+		result.Action = ActionCorrupted
+		result.corruption = corruption
+		return result, true
+	}
+	result.Action = ActionUnboxed
+	if sameTokens(originalTokens, tokens) {
+		result.Action = ActionUnchanged
+	}
+	logger.Debug("unboxed attribute", "action", result.Action)
+	if result.Action == ActionUnchanged {
+		return result, true
+	}
+	setExpressionTokens(block.Body(), name, tags, tokens)
+	return result, true
+}
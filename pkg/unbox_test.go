@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnboxBytes(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = merge(var.tags, (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}) /*</box>*/))
+}
+
+resource "example_resource" "unboxed" {
+  tags = {
+    yor_trace = "456"
+  }
+}
+
+resource "example_resource" "no_tags" {
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	unboxed, changes, diags := boxer.UnboxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Equal(t, formatHcl(t, `resource "example_resource" "example" {
+  tags = merge(var.tags, {
+    yor_trace = "123"
+  })
+}
+
+resource "example_resource" "unboxed" {
+  tags = {
+    yor_trace = "456"
+  }
+}
+
+resource "example_resource" "no_tags" {
+}
+`), formatHcl(t, string(unboxed)))
+	require.Len(t, changes, 1)
+	assert.Equal(t, "example_resource.example", changes[0].Address)
+	assert.Equal(t, "tags", changes[0].Attribute)
+	assert.Equal(t, ActionUnboxed, changes[0].Action)
+	assert.Equal(t, 2, changes[0].Range.Start.Line)
+	assert.Equal(t, 4, changes[0].Range.End.Line)
+}
+
+func TestUnboxBytes_RoundTrip(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = {
+    yor_trace = "123"
+  }
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	boxed, _, diags := boxer.BoxBytes([]byte(code), "main.tf")
+	require.False(t, diags.HasErrors())
+	unboxed, changes, diags := boxer.UnboxBytes(boxed, "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Len(t, changes, 1)
+	assert.Equal(t, formatHcl(t, code), formatHcl(t, string(unboxed)))
+
+	again, changes, diags := boxer.UnboxBytes(unboxed, "main.tf")
+	require.False(t, diags.HasErrors())
+	assert.Empty(t, changes)
+	assert.Equal(t, string(unboxed), string(again))
+}
+
+func TestUnboxBytes_Corrupted(t *testing.T) {
+	code := `resource "example_resource" "example" {
+  tags = (/*<box>*/ (var.yor_toggle ? /*</box>*/ {
+    yor_trace = "123"
+  } /*<box>*/ : {}))
+}
+`
+	boxer, err := NewBoxer(NewOptions("", "yor_toggle", "", "", nil))
+	require.NoError(t, err)
+	unboxed, changes, diags := boxer.UnboxBytes([]byte(code), "main.tf")
+	require.True(t, diags.HasErrors())
+	assert.Empty(t, changes)
+	assert.Equal(t, code, string(unboxed))
+}